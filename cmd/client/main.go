@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"strings"
 
 	"tls-tools/internal/client"
 	"tls-tools/internal/config"
@@ -16,6 +17,7 @@ import (
 
 func main() {
 	configFile := flag.String("config", "client.conf", "configuration file")
+	stateDir := flag.String("state", "", "directory where keys and certs are kept between runs (default: the config's state dir, or .mkcerts next to the config file)")
 	flag.Parse()
 
 	cfgBytes, err := os.ReadFile(*configFile)
@@ -29,8 +31,12 @@ func main() {
 		log.Fatalln(err)
 	}
 
+	if *stateDir != "" {
+		cfg.State = *stateDir
+	}
+	cfg.State = cfg.StateDir(*configFile)
 	log.Println("Generating keys and certificates...")
-	certStore, err := pki.NewStoreFromConfig(cfg.Certs)
+	certStore, err := pki.LoadStore(cfg.Certs, cfg.State, pki.StateOptions{})
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -49,9 +55,48 @@ func main() {
 		fmt.Printf("Host: %s\n", info.Addr)
 		fmt.Printf("  TLS Version: %s\n", tlsutil.TLSVersionString(info.TLSVersion))
 		fmt.Printf("  Cipher suite: %s\n", tls.CipherSuiteName(info.CipherSuite))
+		if info.ALPN != "" {
+			fmt.Printf("  ALPN: %s\n", info.ALPN)
+		}
 		for _, crt := range info.PeerCerts {
 			fmt.Printf("  Certificate: %s\n", crt.Subject.CommonName)
 			fmt.Printf("    Signature algorithm: %s\n", crt.SignatureAlgorithm.String())
 		}
+		if cr := info.CertRequest; cr != nil {
+			fmt.Printf("  Certificate request:\n")
+			if len(cr.AcceptableCAs) == 0 {
+				fmt.Printf("    Acceptable CAs: any\n")
+			}
+			for _, ca := range cr.AcceptableCAs {
+				fmt.Printf("    Acceptable CA: %s\n", ca)
+			}
+			schemes := make([]string, 0, len(cr.SignatureSchemes))
+			for _, s := range cr.SignatureSchemes {
+				schemes = append(schemes, s.String())
+			}
+			fmt.Printf("    Signature schemes: %s\n", strings.Join(schemes, ", "))
+
+			sent := info.ClientCert
+			if sent == "" {
+				sent = "none"
+			}
+			fmt.Printf("  Client certificate: %s\n", sent)
+		}
+		if p := info.Probe; p != nil {
+			fmt.Printf("  Without a client certificate: %s\n", probeResult(p.WithoutCert))
+			if p.SentCert != "" {
+				fmt.Printf("  With client certificate %s: %s\n", p.SentCert, probeResult(p.WithCert))
+			}
+		}
+		for _, r := range info.ALPNProbe {
+			fmt.Printf("  ALPN %s: %s\n", r.Proto, probeResult(r.Err))
+		}
+	}
+}
+
+func probeResult(err error) string {
+	if err != nil {
+		return fmt.Sprintf("rejected (%v)", err)
 	}
+	return "accepted"
 }
@@ -1,19 +1,41 @@
 package main
 
 import (
+	"crypto/x509"
 	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	"tls-tools/internal/config"
+	"tls-tools/internal/keystore"
 	"tls-tools/internal/pki"
 )
 
 func main() {
 	configFile := flag.String("config", "certs.conf", "configuration file")
+	stateDir := flag.String("state", "", "directory where keys and certs are kept between runs (default: the config's state dir, or .mkcerts next to the config file)")
+	outDir := flag.String("out", "", "output directory (default: the config's output dir, or the current directory)")
+	fresh := flag.Bool("fresh", false, "generate everything again instead of keeping unchanged keys and certs")
+	renewDays := flag.Int("renew", 0, "reissue certs that expire within this many days (expired ones always are, with the same key)")
+	newKeys := flag.Bool("new-keys", false, "give certs reissued by -renew new keys")
+	csrFile := flag.String("csr", "", "sign this PKCS#10 request in addition to generating the configured certs")
+	caName := flag.String("ca", "", "CA used to sign -csr (default: the profile's parent)")
+	profileName := flag.String("profile", "", "config entry used as the profile for -csr")
+	certOut := flag.String("cert-out", "", "output file for the cert signed from -csr (default: <csr>.crt)")
+	readKeystore := flag.String("read-keystore", "", "list the entries of this JKS or JCEKS keystore instead of generating anything")
+	storePass := flag.String("storepass", keystore.DefaultPassword, "password for -read-keystore")
 	flag.Parse()
 
+	if *readKeystore != "" {
+		listKeystore(*readKeystore, *storePass)
+		return
+	}
+
 	cfgBytes, err := os.ReadFile(*configFile)
 	if err != nil {
 		log.Fatalln(err)
@@ -25,20 +47,99 @@ func main() {
 		log.Fatalln(err)
 	}
 
+	if *outDir != "" {
+		cfg.Output.Dir = *outDir
+	}
+	if *stateDir != "" {
+		cfg.State = *stateDir
+	}
+	cfg.State = cfg.StateDir(*configFile)
+
 	log.Println("Generating keys and certificates...")
-	store, err := pki.NewStoreFromConfig(cfg.Certs)
+	store, err := pki.LoadStore(cfg.Certs, cfg.State, pki.StateOptions{
+		Ignore:      *fresh,
+		RenewWithin: time.Duration(*renewDays) * 24 * time.Hour,
+		NewKeys:     *newKeys,
+	})
+	if err != nil {
+		log.Fatalln(err)
+	}
+	kept := 0
+	for _, kac := range store {
+		if kac.IsRestored() {
+			kept++
+		}
+	}
+	log.Printf("Kept %d of %d certs from %s", kept, len(store), cfg.State)
+
+	w, err := newWriter(cfg.Output)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	err = w.writeStore(store, cfg.Certs)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if *csrFile != "" {
+		signCSR(cfg, store, *csrFile, *caName, *profileName, *certOut)
+	}
+}
+
+func signCSR(cfg config.Config, store pki.Store, csrFile, caName, profileName, certOut string) {
+	csr, err := pki.ReadCSR(csrFile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	var profile config.Cert
+	if profileName != "" {
+		var ok bool
+		profile, ok = cfg.Certs[profileName]
+		if !ok {
+			log.Fatalf("profile not found: %s", profileName)
+		}
+	}
+
+	if certOut == "" {
+		certOut = strings.TrimSuffix(csrFile, filepath.Ext(csrFile)) + ".crt"
+	}
+
+	log.Printf("Signing %s...", csrFile)
+	kac, err := store.SignCSR(filepath.Base(certOut), csr, profile, caName)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	err = os.WriteFile(certOut, kac.GetCertPEM(), 0644)
+	if err != nil {
+		log.Fatalln(err)
+	}
+}
+
+func listKeystore(file, password string) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	entries, err := keystore.ReadJKS(data, password)
 	if err != nil {
 		log.Fatalln(err)
 	}
 
-	for name, entry := range store {
-		err = os.WriteFile(name+".key", entry.GetKeyPEM(), 0600)
-		if err != nil {
-			log.Fatalln(err)
+	for _, e := range entries {
+		kind := "trustedCertEntry"
+		if e.PrivateKey != nil {
+			kind = "PrivateKeyEntry"
 		}
-		err = os.WriteFile(name+".crt", entry.GetCertPEM(), 0644)
-		if err != nil {
-			log.Fatalln(err)
+		fmt.Printf("%s, %s, %s\n", e.Alias, e.Date.Format("2006-01-02 15:04:05"), kind)
+		for i, der := range e.Certs {
+			crt, err := x509.ParseCertificate(der)
+			if err != nil {
+				log.Fatalln(err)
+			}
+			fmt.Printf("  [%d] %s (issuer: %s)\n", i, crt.Subject, crt.Issuer)
 		}
 	}
 }
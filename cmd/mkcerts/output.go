@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/keystore"
+	"tls-tools/internal/pki"
+)
+
+const defaultFilenames = "{{.Slug}}.{{.Ext}}"
+
+var extensions = map[string]string{
+	"key":       "key",
+	"crt":       "crt",
+	"crl":       "crl",
+	"csr":       "csr",
+	"chain":     "chain.pem",
+	"fullchain": "fullchain.pem",
+	"combined":  "pem",
+	"p12":       "p12",
+	"jks":       "jks",
+	"key-der":   "key.der",
+	"crt-der":   "der",
+	"p7b":       "p7b",
+	"p7b-pem":   "p7b.pem",
+}
+
+type writer struct {
+	cfg       config.Output
+	filenames *template.Template
+	manifest  manifest
+}
+
+type manifest struct {
+	Certs            []manifestEntry `json:"certs"`
+	Roots            string          `json:"roots,omitempty"`
+	TrustStore       string          `json:"trustStore,omitempty"`
+	PKCS12TrustStore string          `json:"pkcs12TrustStore,omitempty"`
+	HashedDir        string          `json:"hashedDir,omitempty"`
+}
+
+type manifestEntry struct {
+	Name   string            `json:"name"`
+	Issuer string            `json:"issuer"`
+	Serial string            `json:"serial"`
+	SHA256 string            `json:"sha256"`
+	Files  map[string]string `json:"files"`
+}
+
+type filenameData struct {
+	Name string
+	Slug string
+	Kind string
+	Ext  string
+}
+
+func newWriter(cfg config.Output) (*writer, error) {
+	if cfg.Filenames == "" {
+		cfg.Filenames = defaultFilenames
+	}
+	t, err := template.New("filenames").Option("missingkey=error").Parse(cfg.Filenames)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filenames template: %w", err)
+	}
+	return &writer{cfg: cfg, filenames: t}, nil
+}
+
+func (w *writer) writeStore(store pki.Store, certs map[string]config.Cert) error {
+	names := make([]string, 0, len(store))
+	for name := range store {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var roots []byte
+	var rootNames []string
+	for _, name := range names {
+		entry := store[name]
+		err := w.writeEntry(entry, certs[name], store)
+		if err != nil {
+			return err
+		}
+		if entry.IsRootCA() {
+			roots = append(roots, entry.GetCertPEM()...)
+			rootNames = append(rootNames, name)
+		}
+	}
+
+	if w.cfg.Roots != "" {
+		err := w.write(w.cfg.Roots, roots, 0644)
+		if err != nil {
+			return err
+		}
+		w.manifest.Roots = w.cfg.Roots
+	}
+
+	if ts := w.cfg.TrustStore; ts != nil {
+		ks, err := keystore.NewJKSTrustStore(rootNames, store, ts.JKS)
+		if err != nil {
+			return err
+		}
+		err = w.write(ts.File, ks, 0644)
+		if err != nil {
+			return err
+		}
+		w.manifest.TrustStore = ts.File
+	}
+
+	if ts := w.cfg.PKCS12TrustStore; ts != nil {
+		p12, err := keystore.NewPKCS12TrustStore(rootNames, store, ts.PKCS12)
+		if err != nil {
+			return err
+		}
+		err = w.write(ts.File, p12, 0644)
+		if err != nil {
+			return err
+		}
+		w.manifest.PKCS12TrustStore = ts.File
+	}
+
+	if w.cfg.HashedDir != nil {
+		err := w.writeHashedDir(store, names)
+		if err != nil {
+			return err
+		}
+		w.manifest.HashedDir = w.cfg.HashedDir.Dir
+	}
+
+	if w.cfg.Manifest != "" {
+		b, err := json.MarshalIndent(w.manifest, "", "  ")
+		if err != nil {
+			return err
+		}
+		return w.write(w.cfg.Manifest, append(b, '\n'), 0644)
+	}
+
+	return nil
+}
+
+// writeHashedDir writes the CA certs, and maybe their CRLs, in the given order so collisions are numbered the same way
+// each time.
+func (w *writer) writeHashedDir(store pki.Store, names []string) error {
+	certs := make(map[uint32]int)
+	crls := make(map[uint32]int)
+	for _, name := range names {
+		entry := store[name]
+		if !entry.GetCertificate().IsCA {
+			continue
+		}
+
+		h, err := pki.SubjectHash(entry.GetCertificate().RawSubject)
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+		err = w.write(filepath.Join(w.cfg.HashedDir.Dir, fmt.Sprintf("%08x.%d", h, certs[h])), entry.GetCertPEM(), 0644)
+		if err != nil {
+			return err
+		}
+		certs[h]++
+
+		if crlPEM := entry.GetCRLPEM(); w.cfg.HashedDir.CRLs && crlPEM != nil {
+			err = w.write(filepath.Join(w.cfg.HashedDir.Dir, fmt.Sprintf("%08x.r%d", h, crls[h])), crlPEM, 0644)
+			if err != nil {
+				return err
+			}
+			crls[h]++
+		}
+	}
+	return nil
+}
+
+func (w *writer) writeEntry(entry pki.KeyAndCert, cfg config.Cert, store pki.Store) error {
+	name := entry.GetName()
+	crt := entry.GetCertificate()
+	fingerprint := sha256.Sum256(entry.GetCertDER())
+
+	issuer := entry.GetParentName()
+	if issuer == "" {
+		issuer = name
+	}
+	me := manifestEntry{
+		Name:   name,
+		Issuer: issuer,
+		Serial: crt.SerialNumber.Text(16),
+		SHA256: hex.EncodeToString(fingerprint[:]),
+		Files:  make(map[string]string),
+	}
+
+	files := map[string][]byte{"crt": entry.GetCertPEM()}
+	keyPEM := entry.GetKeyPEM()
+	if keyFormat := w.keyFormat(cfg); keyPEM != nil && keyFormat != nil {
+		var err error
+		keyPEM, err = keystore.EncodePrivateKey(entry, *keyFormat)
+		if err != nil {
+			return err
+		}
+	}
+	if keyPEM != nil {
+		files["key"] = keyPEM
+		if w.cfg.Combined {
+			files["combined"] = append(append([]byte{}, keyPEM...), entry.GetCertPEM()...)
+		}
+	}
+	if w.cfg.DER {
+		files["crt-der"] = entry.GetCertDER()
+		if keyPEM != nil {
+			block, _ := pem.Decode(keyPEM)
+			if len(block.Headers) > 0 || block.Type == "OPENSSH PRIVATE KEY" {
+				return fmt.Errorf("%s: OpenSSH and legacy encrypted keys have no DER encoding", name)
+			}
+			files["key-der"] = block.Bytes
+		}
+	}
+	if w.cfg.PKCS7 {
+		p7, err := keystore.NewPKCS7(entry.GetCertChainDER())
+		if err != nil {
+			return err
+		}
+		files["p7b"] = p7
+		files["p7b-pem"] = pem.EncodeToMemory(&pem.Block{Type: "PKCS7", Bytes: p7})
+	}
+	if crlPEM := entry.GetCRLPEM(); crlPEM != nil {
+		files["crl"] = crlPEM
+	}
+	if cfg.Request != nil {
+		csrDER, err := pki.NewCSR(entry, *cfg.Request)
+		if err != nil {
+			return err
+		}
+		files["csr"] = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER})
+	}
+
+	// A cert issued by a root has no intermediates, and so no chain file
+	intermediates := encodeCerts(entry.GetIntermediatesDER())
+	if w.cfg.Chain && len(intermediates) > 0 {
+		files["chain"] = intermediates
+	}
+	if w.cfg.FullChain {
+		files["fullchain"] = append(entry.GetCertPEM(), intermediates...)
+	}
+
+	if cfg.PKCS12 != nil {
+		p12, err := keystore.NewPKCS12(name, store, *cfg.PKCS12)
+		if err != nil {
+			return err
+		}
+		files["p12"] = p12
+	}
+	if cfg.JKS != nil {
+		ks, err := keystore.NewJKS(name, store, *cfg.JKS)
+		if err != nil {
+			return err
+		}
+		files["jks"] = ks
+	}
+
+	for kind, content := range files {
+		fn, err := w.filename(name, kind)
+		if err != nil {
+			return err
+		}
+		perm := os.FileMode(0644)
+		if kind == "key" || kind == "key-der" || kind == "combined" || kind == "p12" || kind == "jks" {
+			perm = 0600
+		}
+		err = w.write(fn, content, perm)
+		if err != nil {
+			return err
+		}
+		me.Files[kind] = fn
+	}
+
+	w.manifest.Certs = append(w.manifest.Certs, me)
+	return nil
+}
+
+// keyFormat returns the cert's key format, or else the output's.
+func (w *writer) keyFormat(cfg config.Cert) *config.KeyFormat {
+	if cfg.KeyFormat != nil {
+		return cfg.KeyFormat
+	}
+	return w.cfg.KeyFormat
+}
+
+func (w *writer) filename(name, kind string) (string, error) {
+	slug := pki.Slug(name)
+	if slug == "" && strings.Contains(w.cfg.Filenames, ".Slug") {
+		return "", fmt.Errorf("%s: no ASCII letters or digits to make a file name from", name)
+	}
+	var b bytes.Buffer
+	err := w.filenames.Execute(&b, filenameData{Name: name, Slug: slug, Kind: kind, Ext: extensions[kind]})
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	return b.String(), nil
+}
+
+func (w *writer) write(filename string, content []byte, perm os.FileMode) error {
+	p := filepath.Join(w.cfg.Dir, filename)
+	err := os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(p, content, perm)
+}
+
+func encodeCerts(ders [][]byte) []byte {
+	var b []byte
+	for _, der := range ders {
+		b = append(b, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+	return b
+}
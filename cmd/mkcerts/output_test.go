@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/pki"
+)
+
+func TestWriter_chains(t *testing.T) {
+	certs := map[string]config.Cert{
+		"root":         {KeyType: "P-256", Purpose: "root-ca"},
+		"intermediate": {KeyType: "P-256", Purpose: "intermediate-ca", Parent: "root"},
+		"leaf":         {KeyType: "P-256", Parent: "intermediate"},
+		"direct":       {KeyType: "P-256", Parent: "root"},
+	}
+	store, err := pki.NewStoreFromConfig(certs)
+	assert.Nil(t, err)
+
+	dir := t.TempDir()
+	w, err := newWriter(config.Output{Dir: dir, Chain: true, FullChain: true})
+	assert.Nil(t, err)
+	assert.Nil(t, w.writeStore(store, certs))
+
+	// leaf first, then the intermediate, and no root
+	assert.Equal(t, [][]byte{store["leaf"].GetCertDER(), store["intermediate"].GetCertDER()},
+		readCerts(t, filepath.Join(dir, "leaf.fullchain.pem")))
+	assert.Equal(t, [][]byte{store["intermediate"].GetCertDER()}, readCerts(t, filepath.Join(dir, "leaf.chain.pem")))
+
+	assert.Equal(t, [][]byte{store["direct"].GetCertDER()}, readCerts(t, filepath.Join(dir, "direct.fullchain.pem")))
+	assert.NoFileExists(t, filepath.Join(dir, "direct.chain.pem"))
+}
+
+func readCerts(t *testing.T, path string) [][]byte {
+	b, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	var ders [][]byte
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			return ders
+		}
+		ders = append(ders, block.Bytes)
+	}
+}
+
+func TestWriter_filenames(t *testing.T) {
+	certs := map[string]config.Cert{"Root CA": {KeyType: "P-256", Purpose: "root-ca"}}
+	store, err := pki.NewStoreFromConfig(certs)
+	assert.Nil(t, err)
+
+	dir := t.TempDir()
+	w, err := newWriter(config.Output{Dir: dir})
+	assert.Nil(t, err)
+	assert.Nil(t, w.writeStore(store, certs))
+	assert.FileExists(t, filepath.Join(dir, "root-ca.crt"))
+
+	// names are still there for those who want them
+	w, err = newWriter(config.Output{Dir: dir, Filenames: "{{.Name}}.{{.Ext}}"})
+	assert.Nil(t, err)
+	assert.Nil(t, w.writeStore(store, certs))
+	assert.FileExists(t, filepath.Join(dir, "Root CA.crt"))
+
+	certs = map[string]config.Cert{"根": {KeyType: "P-256", Purpose: "root-ca"}}
+	store, err = pki.NewStoreFromConfig(certs)
+	assert.Nil(t, err)
+	w, err = newWriter(config.Output{Dir: dir})
+	assert.Nil(t, err)
+	assert.ErrorContains(t, w.writeStore(store, certs), "根")
+}
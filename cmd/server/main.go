@@ -7,14 +7,18 @@ import (
 	"log"
 	"os"
 	"os/signal"
+	"sync"
 
+	"tls-tools/internal/acme"
 	"tls-tools/internal/config"
 	"tls-tools/internal/pki"
+	"tls-tools/internal/pkihttp"
 	"tls-tools/internal/server"
 )
 
 func main() {
 	configFile := flag.String("config", "server.conf", "configuration file")
+	stateDir := flag.String("state", "", "directory where keys and certs are kept between runs (default: the config's state dir, or .mkcerts next to the config file)")
 	flag.Parse()
 
 	cfgBytes, err := os.ReadFile(*configFile)
@@ -28,8 +32,12 @@ func main() {
 		log.Fatalln(err)
 	}
 
+	if *stateDir != "" {
+		cfg.State = *stateDir
+	}
+	cfg.State = cfg.StateDir(*configFile)
 	log.Println("Generating keys and certificates...")
-	certStore, err := pki.NewStoreFromConfig(cfg.Certs)
+	certStore, err := pki.LoadStore(cfg.Certs, cfg.State, pki.StateOptions{})
 	if err != nil {
 		log.Fatalln(err)
 	}
@@ -39,6 +47,24 @@ func main() {
 		log.Fatalln(err)
 	}
 
+	services := []service{srv}
+
+	acmeServers, err := acme.NewServersFromConfig(cfg.ACME, certStore)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, a := range acmeServers {
+		services = append(services, a)
+	}
+
+	httpServers, err := pkihttp.NewServersFromConfig(cfg.HTTP, certStore)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	for _, h := range httpServers {
+		services = append(services, h)
+	}
+
 	ctx, cancel := context.WithCancel(context.Background())
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, os.Interrupt)
@@ -49,5 +75,17 @@ func main() {
 	}()
 
 	log.Println("Listening for connections...")
-	srv.Start(ctx)
+	wg := sync.WaitGroup{}
+	for _, svc := range services {
+		wg.Add(1)
+		go func(svc service) {
+			defer wg.Done()
+			svc.Start(ctx)
+		}(svc)
+	}
+	wg.Wait()
+}
+
+type service interface {
+	Start(ctx context.Context)
 }
@@ -2,7 +2,10 @@ module tls-tools
 
 go 1.19
 
-require github.com/stretchr/testify v1.8.1
+require (
+	github.com/stretchr/testify v1.8.1
+	golang.org/x/crypto v0.33.0
+)
 
 require (
 	github.com/davecgh/go-spew v1.1.1 // indirect
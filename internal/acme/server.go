@@ -0,0 +1,894 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/pki"
+	"tls-tools/internal/random"
+)
+
+const (
+	directoryPath  = "/dir"
+	noncePath      = "/nonce"
+	newAccountPath = "/new-account"
+	newOrderPath   = "/new-order"
+	revokeCertPath = "/revoke-cert"
+	keyChangePath  = "/key-change"
+	accountPath    = "/acct/"
+	ordersPath     = "/orders/"
+	orderPath      = "/order/"
+	authzPath      = "/authz/"
+	challengePath  = "/chall/"
+	finalizePath   = "/finalize/"
+	certPath       = "/cert/"
+)
+
+const (
+	maxRequestSize   = 1 << 20
+	orderLifetime    = 24 * time.Hour
+	nonceLifetime    = time.Hour
+	retryAfterSecond = "1"
+)
+
+func NewServersFromConfig(cfg map[string]config.ACMEServer, store pki.Store) ([]*Server, error) {
+	servers := make([]*Server, 0, len(cfg))
+	for addr, sc := range cfg {
+		s, err := NewServerFromConfig(addr, sc, store)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, s)
+	}
+	return servers, nil
+}
+
+func NewServerFromConfig(addr string, cfg config.ACMEServer, store pki.Store) (*Server, error) {
+	issuer, ok := store[cfg.Issuer]
+	if !ok {
+		return nil, fmt.Errorf("%s: issuer not found: %s", addr, cfg.Issuer)
+	}
+	if !issuer.GetCertificate().IsCA || issuer.GetPrivateKey() == nil {
+		return nil, fmt.Errorf("%s: issuer is not a CA: %s", addr, cfg.Issuer)
+	}
+
+	kac, ok := store[cfg.Cert]
+	if !ok {
+		return nil, fmt.Errorf("%s: certificate not found: %s", addr, cfg.Cert)
+	}
+	if kac.GetPrivateKey() == nil {
+		return nil, fmt.Errorf("%s: no private key for certificate: %s", addr, cfg.Cert)
+	}
+
+	s := Server{
+		Addr: addr,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{{
+				Certificate: kac.GetCertChainDER(),
+				PrivateKey:  kac.GetPrivateKey(),
+			}},
+		},
+		cfg:      cfg,
+		store:    store,
+		resolver: net.DefaultResolver,
+		nonces:   make(map[string]time.Time),
+		accounts: make(map[string]*account),
+		orders:   make(map[string]*order),
+		authzs:   make(map[string]*authorization),
+		challs:   make(map[string]*challenge),
+		certs:    make(map[string]*issuedCert),
+	}
+
+	if cfg.Profile != "" {
+		p, ok := store[cfg.Profile]
+		if !ok {
+			return nil, fmt.Errorf("%s: profile not found: %s", addr, cfg.Profile)
+		}
+		s.profile = newProfile(p.GetConfig())
+	}
+
+	if cfg.Errors != nil {
+		s.errors = *cfg.Errors
+		if s.errors.ValidationDelay != "" {
+			var err error
+			s.delay, err = time.ParseDuration(s.errors.ValidationDelay)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid validation delay: %w", addr, err)
+			}
+		}
+	}
+
+	if cfg.DNSResolver != "" {
+		s.resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				d := net.Dialer{}
+				return d.DialContext(ctx, network, cfg.DNSResolver)
+			},
+		}
+	}
+
+	return &s, nil
+}
+
+// newProfile keeps the parts of a store entry that make sense for every cert issued from it: its validity, key usages,
+// extensions and the like. The subject and names come from each order, and the serial, key IDs and issuer name from
+// the key and the CA. What the entry's own files and revocation are doesn't apply to issued certs.
+func newProfile(c config.Cert) config.Cert {
+	c.Subject = nil
+	c.Parent = ""
+	c.CSR = ""
+	c.DNSNames = nil
+	c.IPAddresses = nil
+	c.EmailAddresses = nil
+	c.URIs = nil
+	c.SerialNumber = nil
+	c.SameSerialAs = ""
+	c.SubjectKeyId = nil
+	c.AuthorityKeyId = nil
+	c.Issuer = nil
+	c.Revoked = nil
+	c.Request = nil
+	c.CRL = nil
+	c.PKCS12 = nil
+	c.JKS = nil
+	c.KeyFormat = nil
+	return c
+}
+
+// Server is an RFC 8555 certificate authority that issues certs from a CA in the store.
+type Server struct {
+	Addr      string
+	TLSConfig *tls.Config
+
+	cfg      config.ACMEServer
+	store    pki.Store
+	profile  config.Cert
+	errors   config.ACMEErrors
+	delay    time.Duration
+	resolver *net.Resolver
+
+	mu       sync.Mutex
+	nonces   map[string]time.Time // when each was issued
+	accounts map[string]*account
+	orders   map[string]*order
+	authzs   map[string]*authorization
+	challs   map[string]*challenge
+	certs    map[string]*issuedCert
+}
+
+func (s *Server) Start(ctx context.Context) {
+	l, err := tls.Listen("tcp", s.Addr, s.TLSConfig)
+	if err != nil {
+		log.Println(fmt.Errorf("tls.Listen: %w", err))
+		return
+	}
+
+	hs := &http.Server{Handler: s.Handler()}
+	go func() {
+		err := hs.Serve(l)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Println(fmt.Errorf("hs.Serve: %w", err))
+		}
+	}()
+
+	<-ctx.Done()
+	err = hs.Close()
+	if err != nil {
+		log.Println(fmt.Errorf("hs.Close: %w", err))
+	}
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc(directoryPath, s.handleDirectory)
+	mux.HandleFunc(noncePath, s.handleNonce)
+	mux.HandleFunc(newAccountPath, s.post(jwkOnly, s.newAccount))
+	mux.HandleFunc(newOrderPath, s.post(kidOnly, s.newOrder))
+	mux.HandleFunc(revokeCertPath, s.post(jwkOrKid, s.revokeCert))
+	mux.HandleFunc(keyChangePath, s.post(kidOnly, s.keyChange))
+	mux.HandleFunc(accountPath, s.post(kidOnly, s.account))
+	mux.HandleFunc(ordersPath, s.post(kidOnly, s.accountOrders))
+	mux.HandleFunc(orderPath, s.post(kidOnly, s.order))
+	mux.HandleFunc(authzPath, s.post(kidOnly, s.authorization))
+	mux.HandleFunc(challengePath, s.post(kidOnly, s.challenge))
+	mux.HandleFunc(finalizePath, s.post(kidOnly, s.finalize))
+	mux.HandleFunc(certPath, s.post(kidOnly, s.certificate))
+	return mux
+}
+
+func (s *Server) handleDirectory(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]any{
+		"newNonce":   url(r, noncePath),
+		"newAccount": url(r, newAccountPath),
+		"newOrder":   url(r, newOrderPath),
+		"revokeCert": url(r, revokeCertPath),
+		"keyChange":  url(r, keyChangePath),
+		"meta": map[string]any{
+			"externalAccountRequired": false,
+		},
+	})
+}
+
+func (s *Server) handleNonce(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.addNonce(w)
+	w.Header().Set("Cache-Control", "no-store")
+	addIndexLink(w, r)
+	if r.Method == http.MethodHead {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+type keyMode int
+
+const (
+	kidOnly keyMode = iota
+	jwkOnly
+	jwkOrKid
+)
+
+type request struct {
+	r       *http.Request
+	id      string
+	payload []byte
+	account *account
+	key     crypto.PublicKey
+}
+
+func (req *request) isPostAsGet() bool {
+	return len(req.payload) == 0
+}
+
+type handlerFunc func(w http.ResponseWriter, req *request) *problem
+
+func (s *Server) post(mode keyMode, h handlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		s.addNonce(w)
+		addIndexLink(w, r)
+
+		if r.Method != http.MethodPost {
+			writeProblem(w, newProblem("malformed", http.StatusMethodNotAllowed, "method not allowed"))
+			return
+		}
+
+		req, prob := s.verify(r, mode)
+		if prob == nil {
+			prob = h(w, req)
+		}
+		if prob != nil {
+			log.Printf("ACME %s %s: %v", r.Method, r.URL.Path, prob)
+			writeProblem(w, prob)
+		}
+	}
+}
+
+// verify checks the JWS in the body of a request and the nonce and URL in its protected header.
+func (s *Server) verify(r *http.Request, mode keyMode) (*request, *problem) {
+	if ct := r.Header.Get("Content-Type"); ct != "application/jose+json" {
+		return nil, malformed("invalid content type: %s", ct)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxRequestSize))
+	if err != nil {
+		return nil, malformed("failed to read request: %v", err)
+	}
+
+	var msg jwsMessage
+	err = json.Unmarshal(body, &msg)
+	if err != nil {
+		return nil, malformed("invalid JWS: %v", err)
+	}
+
+	protected, err := b64.DecodeString(msg.Protected)
+	if err != nil {
+		return nil, malformed("invalid protected header: %v", err)
+	}
+	var hdr jwsHeader
+	err = json.Unmarshal(protected, &hdr)
+	if err != nil {
+		return nil, malformed("invalid protected header: %v", err)
+	}
+
+	if hdr.URL != url(r, r.URL.Path) {
+		return nil, newProblem("unauthorized", http.StatusUnauthorized, "url in JWS header doesn't match request: %s", hdr.URL)
+	}
+
+	if issued, ok := s.nonces[hdr.Nonce]; !ok || time.Since(issued) > nonceLifetime {
+		return nil, newProblem("badNonce", http.StatusBadRequest, "invalid nonce: %s", hdr.Nonce)
+	}
+	delete(s.nonces, hdr.Nonce)
+	if random.Chance(s.errors.BadNonce) {
+		return nil, newProblem("badNonce", http.StatusBadRequest, "nonce rejected on purpose: %s", hdr.Nonce)
+	}
+
+	req := request{r: r, id: path.Base(r.URL.Path)}
+	switch {
+	case len(hdr.JWK) > 0 && hdr.KID == "" && mode != kidOnly:
+		req.key, err = parseJWK(hdr.JWK)
+		if err != nil {
+			return nil, newProblem("badPublicKey", http.StatusBadRequest, "invalid JWK: %v", err)
+		}
+	case hdr.KID != "" && len(hdr.JWK) == 0 && mode != jwkOnly:
+		acctURL := url(r, accountPath)
+		a, ok := s.accounts[strings.TrimPrefix(hdr.KID, acctURL)]
+		if !ok || !strings.HasPrefix(hdr.KID, acctURL) {
+			return nil, newProblem("accountDoesNotExist", http.StatusBadRequest, "no such account: %s", hdr.KID)
+		}
+		if a.status != statusValid {
+			return nil, newProblem("unauthorized", http.StatusUnauthorized, "account is %s", a.status)
+		}
+		req.account = a
+		req.key = a.key
+	case mode == jwkOnly:
+		return nil, malformed("request must be signed with a JWK")
+	default:
+		return nil, malformed("request must be signed by an account (kid)")
+	}
+
+	sig, err := b64.DecodeString(msg.Signature)
+	if err != nil {
+		return nil, malformed("invalid signature encoding: %v", err)
+	}
+	err = verifySignature(hdr.Alg, req.key, []byte(msg.Protected+"."+msg.Payload), sig)
+	if err != nil {
+		return nil, newProblem("malformed", http.StatusBadRequest, "JWS verification failed: %v", err)
+	}
+
+	req.payload, err = b64.DecodeString(msg.Payload)
+	if err != nil {
+		return nil, malformed("invalid payload encoding: %v", err)
+	}
+
+	return &req, nil
+}
+
+func (s *Server) newAccount(w http.ResponseWriter, req *request) *problem {
+	var p struct {
+		Contact              []string `json:"contact"`
+		TermsOfServiceAgreed bool     `json:"termsOfServiceAgreed"`
+		OnlyReturnExisting   bool     `json:"onlyReturnExisting"`
+	}
+	if prob := unmarshalPayload(req, &p); prob != nil {
+		return prob
+	}
+
+	thumb, err := thumbprint(req.key)
+	if err != nil {
+		return newProblem("badPublicKey", http.StatusBadRequest, "%v", err)
+	}
+
+	for _, a := range s.accounts {
+		if a.thumb == thumb {
+			w.Header().Set("Location", url(req.r, accountPath+a.id))
+			writeJSON(w, http.StatusOK, s.accountResource(req.r, a))
+			return nil
+		}
+	}
+
+	if p.OnlyReturnExisting {
+		return newProblem("accountDoesNotExist", http.StatusBadRequest, "no account exists with this key")
+	}
+
+	a := &account{
+		id:      newID(),
+		key:     req.key,
+		thumb:   thumb,
+		status:  statusValid,
+		contact: p.Contact,
+	}
+	s.accounts[a.id] = a
+	log.Printf("ACME: created account %s", a.id)
+
+	w.Header().Set("Location", url(req.r, accountPath+a.id))
+	writeJSON(w, http.StatusCreated, s.accountResource(req.r, a))
+	return nil
+}
+
+func (s *Server) account(w http.ResponseWriter, req *request) *problem {
+	if req.id != req.account.id {
+		return newProblem("unauthorized", http.StatusUnauthorized, "account doesn't match key")
+	}
+
+	if !req.isPostAsGet() {
+		var p struct {
+			Contact []string `json:"contact"`
+			Status  string   `json:"status"`
+		}
+		if prob := unmarshalPayload(req, &p); prob != nil {
+			return prob
+		}
+		if p.Contact != nil {
+			req.account.contact = p.Contact
+		}
+		if p.Status == statusDeactivated {
+			req.account.status = statusDeactivated
+		} else if p.Status != "" {
+			return malformed("invalid account status: %s", p.Status)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, s.accountResource(req.r, req.account))
+	return nil
+}
+
+func (s *Server) accountOrders(w http.ResponseWriter, req *request) *problem {
+	if req.id != req.account.id {
+		return newProblem("unauthorized", http.StatusUnauthorized, "account doesn't match key")
+	}
+
+	urls := make([]string, 0, len(req.account.orders))
+	for _, id := range req.account.orders {
+		if s.orders[id].status == statusPending || s.orders[id].status == statusReady ||
+			s.orders[id].status == statusProcessing {
+			urls = append(urls, url(req.r, orderPath+id))
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"orders": urls})
+	return nil
+}
+
+func (s *Server) newOrder(w http.ResponseWriter, req *request) *problem {
+	if random.Chance(s.errors.RateLimited) {
+		w.Header().Set("Retry-After", retryAfterSecond)
+		return newProblem("rateLimited", http.StatusTooManyRequests, "too many new orders (on purpose)")
+	}
+
+	var p struct {
+		Identifiers []identifier `json:"identifiers"`
+		NotBefore   string       `json:"notBefore"`
+		NotAfter    string       `json:"notAfter"`
+	}
+	if prob := unmarshalPayload(req, &p); prob != nil {
+		return prob
+	}
+	if len(p.Identifiers) == 0 {
+		return malformed("no identifiers in order")
+	}
+
+	o := &order{
+		id:        newID(),
+		account:   req.account.id,
+		status:    statusPending,
+		expires:   time.Now().Add(orderLifetime),
+		notBefore: p.NotBefore,
+		notAfter:  p.NotAfter,
+	}
+
+	for _, ident := range p.Identifiers {
+		if ident.Type != "dns" {
+			return newProblem("unsupportedIdentifier", http.StatusBadRequest, "unsupported identifier type: %s", ident.Type)
+		}
+		value := strings.ToLower(strings.TrimSuffix(ident.Value, "."))
+		if value == "" || strings.HasPrefix(value, "*.") && len(value) < 3 {
+			return newProblem("rejectedIdentifier", http.StatusBadRequest, "invalid identifier: %s", ident.Value)
+		}
+		o.identifiers = append(o.identifiers, identifier{Type: ident.Type, Value: value})
+		o.authzs = append(o.authzs, s.newAuthorization(req.account.id, value, o.expires))
+	}
+
+	s.orders[o.id] = o
+	req.account.orders = append(req.account.orders, o.id)
+	log.Printf("ACME: created order %s for %v", o.id, o.identifiers)
+
+	w.Header().Set("Location", url(req.r, orderPath+o.id))
+	writeJSON(w, http.StatusCreated, s.orderResource(req.r, o))
+	return nil
+}
+
+func (s *Server) newAuthorization(accountID, value string, expires time.Time) *authorization {
+	a := &authorization{
+		id:         newID(),
+		account:    accountID,
+		status:     statusPending,
+		expires:    expires,
+		identifier: identifier{Type: "dns", Value: value},
+	}
+
+	types := []string{"http-01", "dns-01", "tls-alpn-01"}
+	if strings.HasPrefix(value, "*.") {
+		a.identifier.Value = strings.TrimPrefix(value, "*.")
+		a.wildcard = true
+		types = []string{"dns-01"}
+	}
+
+	token := b64.EncodeToString(random.Bytes(32))
+	for _, t := range types {
+		c := &challenge{
+			id:     newID(),
+			typ:    t,
+			token:  token,
+			status: statusPending,
+			authz:  a,
+		}
+		a.challenges = append(a.challenges, c)
+		s.challs[c.id] = c
+	}
+
+	s.authzs[a.id] = a
+	return a
+}
+
+func (s *Server) order(w http.ResponseWriter, req *request) *problem {
+	o, ok := s.orders[req.id]
+	if !ok || o.account != req.account.id {
+		return notFound()
+	}
+
+	writeJSON(w, http.StatusOK, s.orderResource(req.r, o))
+	return nil
+}
+
+func (s *Server) authorization(w http.ResponseWriter, req *request) *problem {
+	a, ok := s.authzs[req.id]
+	if !ok || a.account != req.account.id {
+		return notFound()
+	}
+
+	if !req.isPostAsGet() {
+		var p struct {
+			Status string `json:"status"`
+		}
+		if prob := unmarshalPayload(req, &p); prob != nil {
+			return prob
+		}
+		if p.Status != statusDeactivated {
+			return malformed("invalid authorization status: %s", p.Status)
+		}
+		a.status = statusDeactivated
+	}
+
+	writeJSON(w, http.StatusOK, s.authorizationResource(req.r, a))
+	return nil
+}
+
+func (s *Server) challenge(w http.ResponseWriter, req *request) *problem {
+	c, ok := s.challs[req.id]
+	if !ok || c.authz.account != req.account.id {
+		return notFound()
+	}
+
+	if !req.isPostAsGet() && c.status == statusPending && c.authz.status == statusPending {
+		c.status = statusProcessing
+		go s.validate(c, c.token+"."+req.account.thumb)
+	}
+
+	w.Header().Add("Link", fmt.Sprintf(`<%s>;rel="up"`, url(req.r, authzPath+c.authz.id)))
+	writeJSON(w, http.StatusOK, s.challengeResource(req.r, c))
+	return nil
+}
+
+func (s *Server) validate(c *challenge, keyAuth string) {
+	time.Sleep(s.delay)
+
+	var prob *problem
+	if !s.cfg.AutoApprove {
+		prob = s.check(c.typ, c.authz.identifier.Value, c.token, keyAuth)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if prob != nil {
+		log.Printf("ACME: %s challenge for %s failed: %v", c.typ, c.authz.identifier.Value, prob)
+		c.status = statusInvalid
+		c.err = prob
+		c.authz.status = statusInvalid
+		return
+	}
+
+	log.Printf("ACME: %s challenge for %s succeeded", c.typ, c.authz.identifier.Value)
+	c.status = statusValid
+	c.validated = time.Now()
+	if c.authz.status == statusPending {
+		c.authz.status = statusValid
+	}
+}
+
+func (s *Server) finalize(w http.ResponseWriter, req *request) *problem {
+	o, ok := s.orders[req.id]
+	if !ok || o.account != req.account.id {
+		return notFound()
+	}
+
+	s.updateOrder(o)
+	if o.status != statusReady {
+		return newProblem("orderNotReady", http.StatusForbidden, "order is %s", o.status)
+	}
+
+	var p struct {
+		CSR string `json:"csr"`
+	}
+	if prob := unmarshalPayload(req, &p); prob != nil {
+		return prob
+	}
+	der, err := b64.DecodeString(p.CSR)
+	if err != nil {
+		return newProblem("badCSR", http.StatusBadRequest, "invalid CSR encoding: %v", err)
+	}
+	csr, err := x509.ParseCertificateRequest(der)
+	if err != nil {
+		return newProblem("badCSR", http.StatusBadRequest, "invalid CSR: %v", err)
+	}
+	err = csr.CheckSignature()
+	if err != nil {
+		return newProblem("badCSR", http.StatusBadRequest, "invalid CSR signature: %v", err)
+	}
+	if prob := checkCSRNames(csr, o.identifiers); prob != nil {
+		return prob
+	}
+
+	profile := s.profile
+	if o.notBefore != "" {
+		profile.NotBefore = o.notBefore
+	}
+	if o.notAfter != "" {
+		profile.NotAfter = o.notAfter
+	}
+
+	kac, err := s.store.SignCSR("ACME order "+o.id, csr, profile, s.cfg.Issuer)
+	if err != nil {
+		o.status = statusInvalid
+		o.err = newProblem("serverInternal", http.StatusInternalServerError, "failed to issue cert: %v", err)
+		return o.err
+	}
+
+	var chain []byte
+	for _, der := range kac.GetCertChainDER() {
+		chain = append(chain, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})...)
+	}
+
+	ic := &issuedCert{id: newID(), account: req.account.id, chainPEM: chain, der: kac.GetCertDER()}
+	s.certs[ic.id] = ic
+	o.cert = ic.id
+	o.status = statusValid
+	log.Printf("ACME: issued cert %s (serial %x) for order %s", ic.id, kac.GetCertificate().SerialNumber, o.id)
+
+	w.Header().Set("Location", url(req.r, orderPath+o.id))
+	writeJSON(w, http.StatusOK, s.orderResource(req.r, o))
+	return nil
+}
+
+func checkCSRNames(csr *x509.CertificateRequest, identifiers []identifier) *problem {
+	if len(csr.IPAddresses) > 0 || len(csr.EmailAddresses) > 0 || len(csr.URIs) > 0 {
+		return newProblem("badCSR", http.StatusBadRequest, "CSR contains unsupported SANs")
+	}
+
+	names := make(map[string]bool)
+	for _, n := range csr.DNSNames {
+		names[strings.ToLower(n)] = true
+	}
+	if csr.Subject.CommonName != "" {
+		names[strings.ToLower(csr.Subject.CommonName)] = true
+	}
+
+	if len(names) != len(identifiers) {
+		return newProblem("badCSR", http.StatusBadRequest, "names in CSR don't match order")
+	}
+	for _, ident := range identifiers {
+		if !names[ident.Value] {
+			return newProblem("badCSR", http.StatusBadRequest, "CSR is missing %s", ident.Value)
+		}
+	}
+	return nil
+}
+
+func (s *Server) certificate(w http.ResponseWriter, req *request) *problem {
+	ic, ok := s.certs[req.id]
+	if !ok || ic.account != req.account.id {
+		return notFound()
+	}
+
+	w.Header().Set("Content-Type", "application/pem-certificate-chain")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(ic.chainPEM)
+	return nil
+}
+
+func (s *Server) revokeCert(w http.ResponseWriter, req *request) *problem {
+	var p struct {
+		Certificate string `json:"certificate"`
+		Reason      int    `json:"reason"`
+	}
+	if prob := unmarshalPayload(req, &p); prob != nil {
+		return prob
+	}
+	der, err := b64.DecodeString(p.Certificate)
+	if err != nil {
+		return malformed("invalid certificate encoding: %v", err)
+	}
+
+	for _, ic := range s.certs {
+		if !bytes.Equal(ic.der, der) {
+			continue
+		}
+
+		if req.account != nil && req.account.id != ic.account {
+			return newProblem("unauthorized", http.StatusForbidden, "certificate belongs to another account")
+		}
+		if req.account == nil {
+			crt, err := x509.ParseCertificate(der)
+			if err != nil {
+				return malformed("invalid certificate: %v", err)
+			}
+			if k, ok := crt.PublicKey.(interface{ Equal(crypto.PublicKey) bool }); !ok || !k.Equal(req.key) {
+				return newProblem("unauthorized", http.StatusForbidden, "request isn't signed by the certificate's key")
+			}
+		}
+		if ic.revoked {
+			return newProblem("alreadyRevoked", http.StatusBadRequest, "certificate is already revoked")
+		}
+
+		ic.revoked = true
+		log.Printf("ACME: revoked cert %s (reason %d)", ic.id, p.Reason)
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+
+	return notFound()
+}
+
+func (s *Server) keyChange(_ http.ResponseWriter, _ *request) *problem {
+	return malformed("key rollover is not supported")
+}
+
+// updateOrder moves a pending order to ready or invalid based on the state of its authorizations.
+func (s *Server) updateOrder(o *order) {
+	if o.status != statusPending {
+		return
+	}
+
+	ready := true
+	for _, a := range o.authzs {
+		switch a.status {
+		case statusValid:
+		case statusPending:
+			ready = false
+		default:
+			o.status = statusInvalid
+			o.err = newProblem("unauthorized", http.StatusForbidden, "authorization for %s is %s", a.identifier.Value, a.status)
+			for _, c := range a.challenges {
+				if c.err != nil {
+					o.err = c.err
+				}
+			}
+			return
+		}
+	}
+
+	if ready {
+		o.status = statusReady
+	}
+}
+
+func (s *Server) accountResource(r *http.Request, a *account) accountResource {
+	return accountResource{
+		Status:  a.status,
+		Contact: a.contact,
+		Orders:  url(r, ordersPath+a.id),
+	}
+}
+
+func (s *Server) orderResource(r *http.Request, o *order) orderResource {
+	s.updateOrder(o)
+
+	res := orderResource{
+		Status:      o.status,
+		Expires:     o.expires.UTC().Format(time.RFC3339),
+		Identifiers: o.identifiers,
+		NotBefore:   o.notBefore,
+		NotAfter:    o.notAfter,
+		Finalize:    url(r, finalizePath+o.id),
+		Error:       o.err,
+	}
+	for _, a := range o.authzs {
+		res.Authorizations = append(res.Authorizations, url(r, authzPath+a.id))
+	}
+	if o.cert != "" {
+		res.Certificate = url(r, certPath+o.cert)
+	}
+	return res
+}
+
+func (s *Server) authorizationResource(r *http.Request, a *authorization) authorizationResource {
+	res := authorizationResource{
+		Status:     a.status,
+		Expires:    a.expires.UTC().Format(time.RFC3339),
+		Identifier: a.identifier,
+		Wildcard:   a.wildcard,
+	}
+	for _, c := range a.challenges {
+		res.Challenges = append(res.Challenges, s.challengeResource(r, c))
+	}
+	return res
+}
+
+func (s *Server) challengeResource(r *http.Request, c *challenge) challengeResource {
+	res := challengeResource{
+		Type:   c.typ,
+		URL:    url(r, challengePath+c.id),
+		Token:  c.token,
+		Status: c.status,
+		Error:  c.err,
+	}
+	if !c.validated.IsZero() {
+		res.Validated = c.validated.UTC().Format(time.RFC3339)
+	}
+	return res
+}
+
+// addNonce issues a nonce, and forgets the ones that were never used and have expired.
+func (s *Server) addNonce(w http.ResponseWriter) {
+	now := time.Now()
+	for n, issued := range s.nonces {
+		if now.Sub(issued) > nonceLifetime {
+			delete(s.nonces, n)
+		}
+	}
+
+	n := b64.EncodeToString(random.Bytes(16))
+	s.nonces[n] = now
+	w.Header().Set("Replay-Nonce", n)
+}
+
+func unmarshalPayload(req *request, v any) *problem {
+	if req.isPostAsGet() {
+		return nil
+	}
+	err := json.Unmarshal(req.payload, v)
+	if err != nil {
+		return malformed("invalid payload: %v", err)
+	}
+	return nil
+}
+
+func addIndexLink(w http.ResponseWriter, r *http.Request) {
+	w.Header().Add("Link", fmt.Sprintf(`<%s>;rel="index"`, url(r, directoryPath)))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(v)
+	if err != nil {
+		log.Println(fmt.Errorf("json.Encode: %w", err))
+	}
+}
+
+func writeProblem(w http.ResponseWriter, p *problem) {
+	w.Header().Set("Content-Type", "application/problem+json")
+	w.WriteHeader(p.Status)
+	_ = json.NewEncoder(w).Encode(p)
+}
+
+func url(r *http.Request, p string) string {
+	return "https://" + r.Host + p
+}
+
+func newID() string {
+	return hex.EncodeToString(random.Bytes(8))
+}
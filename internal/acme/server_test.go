@@ -0,0 +1,209 @@
+package acme
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/acme"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/pki"
+)
+
+func TestServer_issue(t *testing.T) {
+	_, client, store := newTestServer(t, config.ACMEServer{AutoApprove: true})
+	ctx := context.Background()
+
+	_, err := client.Register(ctx, &acme.Account{Contact: []string{"mailto:test@example.com"}}, acme.AcceptTOS)
+	assert.Nil(t, err)
+
+	o, err := client.AuthorizeOrder(ctx, acme.DomainIDs("a.example.com", "*.example.com"))
+	assert.Nil(t, err)
+	assert.Equal(t, acme.StatusPending, o.Status)
+	assert.Len(t, o.AuthzURLs, 2)
+
+	for _, u := range o.AuthzURLs {
+		z, err := client.GetAuthorization(ctx, u)
+		assert.Nil(t, err)
+		var chal *acme.Challenge
+		for _, c := range z.Challenges {
+			if c.Type == "dns-01" {
+				chal = c
+			}
+		}
+		if z.Wildcard {
+			assert.Len(t, z.Challenges, 1)
+		}
+		_, err = client.Accept(ctx, chal)
+		assert.Nil(t, err)
+		_, err = client.WaitAuthorization(ctx, u)
+		assert.Nil(t, err)
+	}
+
+	o, err = client.WaitOrder(ctx, o.URI)
+	assert.Nil(t, err)
+	assert.Equal(t, acme.StatusReady, o.Status)
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		DNSNames: []string{"a.example.com", "*.example.com"},
+	}, certKey)
+	assert.Nil(t, err)
+
+	chain, _, err := client.CreateOrderCert(ctx, o.FinalizeURL, csr, true)
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, len(chain), 2)
+
+	crt, err := x509.ParseCertificate(chain[0])
+	assert.Nil(t, err)
+	assert.ElementsMatch(t, []string{"a.example.com", "*.example.com"}, crt.DNSNames)
+	assert.Equal(t, "a.example.com", crt.Subject.CommonName)
+	assert.Nil(t, crt.CheckSignatureFrom(store["ca"].GetCertificate()))
+
+	err = client.RevokeCert(ctx, nil, chain[0], acme.CRLReasonKeyCompromise)
+	assert.Nil(t, err)
+}
+
+func TestServer_invalidChallenge(t *testing.T) {
+	_, client, _ := newTestServer(t, config.ACMEServer{HTTPPort: 1})
+	ctx := context.Background()
+
+	_, err := client.Register(ctx, &acme.Account{}, acme.AcceptTOS)
+	assert.Nil(t, err)
+
+	o, err := client.AuthorizeOrder(ctx, acme.DomainIDs("localhost"))
+	assert.Nil(t, err)
+	z, err := client.GetAuthorization(ctx, o.AuthzURLs[0])
+	assert.Nil(t, err)
+	for _, c := range z.Challenges {
+		if c.Type == "http-01" {
+			_, err = client.Accept(ctx, c)
+			assert.Nil(t, err)
+		}
+	}
+
+	_, err = client.WaitAuthorization(ctx, o.AuthzURLs[0])
+	assert.NotNil(t, err)
+	_, err = client.WaitOrder(ctx, o.URI)
+	assert.NotNil(t, err)
+}
+
+func TestServer_errorInjection(t *testing.T) {
+	_, client, _ := newTestServer(t, config.ACMEServer{Errors: &config.ACMEErrors{RateLimited: 1}})
+	client.RetryBackoff = func(int, *http.Request, *http.Response) time.Duration { return -1 }
+	ctx := context.Background()
+
+	_, err := client.Register(ctx, &acme.Account{}, acme.AcceptTOS)
+	assert.Nil(t, err)
+
+	_, err = client.AuthorizeOrder(ctx, acme.DomainIDs("a.example.com"))
+	assert.NotNil(t, err)
+	assert.True(t, strings.Contains(err.Error(), "rateLimited"))
+}
+
+func TestServer_profile(t *testing.T) {
+	_, client, store := newTestServer(t, config.ACMEServer{AutoApprove: true, Profile: "profile"})
+	ctx := context.Background()
+
+	_, err := client.Register(ctx, &acme.Account{}, acme.AcceptTOS)
+	assert.Nil(t, err)
+
+	// The profile's own names, serial and key IDs are not reused, so a second order gets its own cert
+	ca := store["ca"].GetCertificate()
+	var skis [][]byte
+	for _, name := range []string{"a.example.com", "b.example.com"} {
+		chain := issueCert(t, client, name)
+		if !assert.NotEmpty(t, chain) {
+			return
+		}
+		crt, err := x509.ParseCertificate(chain[0])
+		assert.Nil(t, err)
+		assert.Equal(t, []string{name}, crt.DNSNames)
+		assert.Equal(t, name, crt.Subject.CommonName)
+		assert.Contains(t, crt.ExtKeyUsage, x509.ExtKeyUsageClientAuth)
+		assert.NotEqual(t, store["profile"].GetCertificate().SerialNumber, crt.SerialNumber)
+		assert.Equal(t, ca.SubjectKeyId, crt.AuthorityKeyId)
+		assert.Equal(t, ca.Subject.String(), crt.Issuer.String())
+		assert.Nil(t, crt.CheckSignatureFrom(ca))
+		skis = append(skis, crt.SubjectKeyId)
+	}
+	if assert.Len(t, skis, 2) {
+		assert.NotEqual(t, skis[0], skis[1])
+	}
+}
+
+// issueCert orders a cert for a name from a server that approves every challenge.
+func issueCert(t *testing.T, client *acme.Client, name string) [][]byte {
+	ctx := context.Background()
+	o, err := client.AuthorizeOrder(ctx, acme.DomainIDs(name))
+	if !assert.Nil(t, err) {
+		return nil
+	}
+	for _, u := range o.AuthzURLs {
+		z, err := client.GetAuthorization(ctx, u)
+		assert.Nil(t, err)
+		_, err = client.Accept(ctx, z.Challenges[0])
+		assert.Nil(t, err)
+		_, err = client.WaitAuthorization(ctx, u)
+		assert.Nil(t, err)
+	}
+	o, err = client.WaitOrder(ctx, o.URI)
+	assert.Nil(t, err)
+
+	certKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{DNSNames: []string{name}}, certKey)
+	assert.Nil(t, err)
+	chain, _, err := client.CreateOrderCert(ctx, o.FinalizeURL, csr, true)
+	assert.Nil(t, err)
+	return chain
+}
+
+func newTestServer(t *testing.T, cfg config.ACMEServer) (*Server, *acme.Client, pki.Store) {
+	serial := config.HexString("1234")
+	ski := config.HexString("5678")
+	aki := config.HexString("9abc")
+	cn := "profile"
+	eku := "serverAuth,clientAuth"
+	store, err := pki.NewStoreFromConfig(map[string]config.Cert{
+		"ca":   {KeyType: "P-256", Purpose: "root-ca"},
+		"acme": {KeyType: "P-256", Parent: "ca", DNSNames: []string{"127.0.0.1"}},
+		"profile": {KeyType: "P-256", Parent: "ca", DNSNames: []string{"profile.example.com"}, SerialNumber: &serial,
+			Subject: &config.Subject{CN: &cn}, ExtKeyUsage: &eku, SubjectKeyId: &ski, AuthorityKeyId: &aki,
+			Issuer: &config.Subject{CN: &cn}},
+	})
+	assert.Nil(t, err)
+
+	cfg.Issuer = "ca"
+	cfg.Cert = "acme"
+	s, err := NewServerFromConfig("127.0.0.1:0", cfg, store)
+	assert.Nil(t, err)
+
+	ts := httptest.NewUnstartedServer(s.Handler())
+	ts.TLS = s.TLSConfig
+	ts.StartTLS()
+	t.Cleanup(ts.Close)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	hc := ts.Client()
+	hc.Transport.(*http.Transport).TLSClientConfig.InsecureSkipVerify = true
+	client := &acme.Client{
+		Key:          key,
+		HTTPClient:   hc,
+		DirectoryURL: ts.URL + directoryPath,
+	}
+
+	return s, client, store
+}
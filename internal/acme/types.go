@@ -0,0 +1,136 @@
+package acme
+
+import (
+	"crypto"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+const (
+	statusPending     = "pending"
+	statusProcessing  = "processing"
+	statusReady       = "ready"
+	statusValid       = "valid"
+	statusInvalid     = "invalid"
+	statusDeactivated = "deactivated"
+	statusRevoked     = "revoked"
+)
+
+type problem struct {
+	Type   string `json:"type"`
+	Detail string `json:"detail"`
+	Status int    `json:"status,omitempty"`
+}
+
+func (p *problem) Error() string {
+	return fmt.Sprintf("%s: %s", p.Type, p.Detail)
+}
+
+func newProblem(typ string, status int, format string, args ...any) *problem {
+	return &problem{
+		Type:   "urn:ietf:params:acme:error:" + typ,
+		Detail: fmt.Sprintf(format, args...),
+		Status: status,
+	}
+}
+
+func malformed(format string, args ...any) *problem {
+	return newProblem("malformed", http.StatusBadRequest, format, args...)
+}
+
+func notFound() *problem {
+	return newProblem("malformed", http.StatusNotFound, "no such resource")
+}
+
+type identifier struct {
+	Type  string `json:"type"`
+	Value string `json:"value"`
+}
+
+type account struct {
+	id      string
+	key     crypto.PublicKey
+	thumb   string
+	status  string
+	contact []string
+	orders  []string
+}
+
+type order struct {
+	id          string
+	account     string
+	status      string
+	expires     time.Time
+	identifiers []identifier
+	notBefore   string
+	notAfter    string
+	authzs      []*authorization
+	cert        string
+	err         *problem
+}
+
+type authorization struct {
+	id         string
+	account    string
+	status     string
+	expires    time.Time
+	identifier identifier
+	wildcard   bool
+	challenges []*challenge
+}
+
+type challenge struct {
+	id        string
+	typ       string
+	token     string
+	status    string
+	validated time.Time
+	err       *problem
+	authz     *authorization
+}
+
+type issuedCert struct {
+	id       string
+	account  string
+	chainPEM []byte
+	der      []byte
+	revoked  bool
+}
+
+// JSON representations of the resources above
+
+type accountResource struct {
+	Status  string   `json:"status"`
+	Contact []string `json:"contact,omitempty"`
+	Orders  string   `json:"orders"`
+}
+
+type orderResource struct {
+	Status         string       `json:"status"`
+	Expires        string       `json:"expires"`
+	Identifiers    []identifier `json:"identifiers"`
+	NotBefore      string       `json:"notBefore,omitempty"`
+	NotAfter       string       `json:"notAfter,omitempty"`
+	Authorizations []string     `json:"authorizations"`
+	Finalize       string       `json:"finalize"`
+	Certificate    string       `json:"certificate,omitempty"`
+	Error          *problem     `json:"error,omitempty"`
+}
+
+type authorizationResource struct {
+	Status     string              `json:"status"`
+	Expires    string              `json:"expires"`
+	Identifier identifier          `json:"identifier"`
+	Wildcard   bool                `json:"wildcard,omitempty"`
+	Challenges []challengeResource `json:"challenges"`
+}
+
+type challengeResource struct {
+	Type      string   `json:"type"`
+	URL       string   `json:"url"`
+	Token     string   `json:"token"`
+	Status    string   `json:"status"`
+	Validated string   `json:"validated,omitempty"`
+	Error     *problem `json:"error,omitempty"`
+}
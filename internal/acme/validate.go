@@ -0,0 +1,132 @@
+package acme
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	validationTimeout = 10 * time.Second
+	defaultHTTPPort   = 80
+	defaultTLSPort    = 443
+)
+
+var oidACMEIdentifier = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 31}
+
+func (s *Server) check(typ, domain, token, keyAuth string) *problem {
+	switch typ {
+	case "http-01":
+		return s.checkHTTP01(domain, token, keyAuth)
+	case "dns-01":
+		return s.checkDNS01(domain, keyAuth)
+	case "tls-alpn-01":
+		return s.checkTLSALPN01(domain, keyAuth)
+	default:
+		return malformed("unsupported challenge type: %s", typ)
+	}
+}
+
+func (s *Server) checkHTTP01(domain, token, keyAuth string) *problem {
+	port := s.cfg.HTTPPort
+	if port == 0 {
+		port = defaultHTTPPort
+	}
+
+	hc := http.Client{Timeout: validationTimeout}
+	resp, err := hc.Get(fmt.Sprintf("http://%s/.well-known/acme-challenge/%s",
+		net.JoinHostPort(domain, strconv.Itoa(port)), token))
+	if err != nil {
+		return newProblem("connection", http.StatusBadRequest, "%v", err)
+	}
+	defer func() { _ = resp.Body.Close() }()
+
+	if resp.StatusCode != http.StatusOK {
+		return newProblem("unauthorized", http.StatusForbidden, "challenge response has status %d", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxRequestSize))
+	if err != nil {
+		return newProblem("connection", http.StatusBadRequest, "%v", err)
+	}
+	if strings.TrimSpace(string(body)) != keyAuth {
+		return newProblem("incorrectResponse", http.StatusForbidden, "wrong key authorization: %q", body)
+	}
+
+	return nil
+}
+
+func (s *Server) checkDNS01(domain, keyAuth string) *problem {
+	ctx, cancel := context.WithTimeout(context.Background(), validationTimeout)
+	defer cancel()
+
+	records, err := s.resolver.LookupTXT(ctx, "_acme-challenge."+domain)
+	if err != nil {
+		return newProblem("dns", http.StatusBadRequest, "%v", err)
+	}
+
+	h := sha256.Sum256([]byte(keyAuth))
+	expected := b64.EncodeToString(h[:])
+	for _, r := range records {
+		if r == expected {
+			return nil
+		}
+	}
+
+	return newProblem("unauthorized", http.StatusForbidden, "no matching TXT record for _acme-challenge.%s", domain)
+}
+
+func (s *Server) checkTLSALPN01(domain, keyAuth string) *problem {
+	port := s.cfg.TLSPort
+	if port == 0 {
+		port = defaultTLSPort
+	}
+
+	d := net.Dialer{Timeout: validationTimeout}
+	conn, err := tls.DialWithDialer(&d, "tcp", net.JoinHostPort(domain, strconv.Itoa(port)), &tls.Config{
+		ServerName:         domain,
+		NextProtos:         []string{"acme-tls/1"},
+		InsecureSkipVerify: true,
+	})
+	if err != nil {
+		return newProblem("tls", http.StatusBadRequest, "%v", err)
+	}
+	defer func() { _ = conn.Close() }()
+
+	cs := conn.ConnectionState()
+	if cs.NegotiatedProtocol != "acme-tls/1" {
+		return newProblem("tls", http.StatusBadRequest, "server didn't negotiate acme-tls/1")
+	}
+	if len(cs.PeerCertificates) == 0 {
+		return newProblem("tls", http.StatusBadRequest, "no certificate")
+	}
+
+	crt := cs.PeerCertificates[0]
+	if len(crt.DNSNames) != 1 || !strings.EqualFold(crt.DNSNames[0], domain) {
+		return newProblem("incorrectResponse", http.StatusForbidden, "certificate is for %v, not %s", crt.DNSNames, domain)
+	}
+
+	h := sha256.Sum256([]byte(keyAuth))
+	for _, ext := range crt.Extensions {
+		if !ext.Id.Equal(oidACMEIdentifier) {
+			continue
+		}
+		var value []byte
+		_, err := asn1.Unmarshal(ext.Value, &value)
+		if err != nil || !ext.Critical || !bytes.Equal(value, h[:]) {
+			return newProblem("incorrectResponse", http.StatusForbidden, "invalid acmeIdentifier extension")
+		}
+		return nil
+	}
+
+	return newProblem("incorrectResponse", http.StatusForbidden, "missing acmeIdentifier extension")
+}
@@ -3,12 +3,22 @@ package client
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
 	"net"
+	"os"
+	"strings"
+	"time"
 
 	"tls-tools/internal/config"
 	"tls-tools/internal/pki"
 )
 
+// probeTimeout is how long a probe waits for the server to reject its client cert
+const probeTimeout = 500 * time.Millisecond
 
 func NewClientsFromConfig(cfg []config.Client, store pki.Store) (map[string]*Client, error) {
 	clients := make(map[string]*Client, 0)
@@ -45,12 +55,48 @@ func NewClientFromConfig(cfg config.Client, store pki.Store) (*Client, error) {
 		}
 	}
 
-	return &Client{addr, tc}, nil
+	c := Client{Addr: addr, TLSConfig: tc, probe: cfg.ProbeClientAuth, probeALPN: cfg.ProbeALPN}
+	switch strings.ToLower(strings.TrimSpace(cfg.CertSelection)) {
+	case "", "auto":
+	case "first":
+		c.sendFirst = true
+	default:
+		return nil, fmt.Errorf("invalid cert selection: %s", cfg.CertSelection)
+	}
+
+	for _, name := range cfg.Certs {
+		kac, ok := store[name]
+		if !ok {
+			return nil, fmt.Errorf("certificate not found: %s", name)
+		}
+		if kac.GetPrivateKey() == nil {
+			return nil, fmt.Errorf("no private key for certificate: %s", name)
+		}
+		c.certs = append(c.certs, namedCertificate{
+			name: name,
+			cert: tls.Certificate{
+				Certificate: kac.GetCertChainDER(),
+				PrivateKey:  kac.GetPrivateKey(),
+				Leaf:        kac.GetCertificate(),
+			},
+		})
+	}
+
+	return &c, nil
 }
 
 type Client struct {
 	Addr      string
 	TLSConfig *tls.Config
+	certs     []namedCertificate
+	sendFirst bool
+	probe     bool
+	probeALPN []string
+}
+
+type namedCertificate struct {
+	name string
+	cert tls.Certificate
 }
 
 type TLSListenerInfo struct {
@@ -58,27 +104,183 @@ type TLSListenerInfo struct {
 	TLSVersion  uint16
 	PeerCerts   []*x509.Certificate
 	CipherSuite uint16
+	CertRequest *CertificateRequest // what the server asked for, if it asked for a client cert
+	ClientCert  string              // name of the cert sent, if any
+	Probe       *ClientAuthProbe    // if probing was asked for and the server asked for a client cert
+	ALPN        string              // negotiated protocol, if any
+	ALPNProbe   []ALPNResult        // for each protocol probed, in order
+}
+
+// CertificateRequest is what a server puts in its CertificateRequest message.
+type CertificateRequest struct {
+	AcceptableCAs    []string // distinguished names; an empty list means any CA
+	SignatureSchemes []tls.SignatureScheme
+}
+
+// ClientAuthProbe is how the server took a connection without a client cert, and then one with a cert.
+type ClientAuthProbe struct {
+	WithoutCert error  // nil if it was accepted
+	WithCert    error  // nil if it was accepted; not tried without a cert to send
+	SentCert    string // name of the cert sent the second time
+}
+
+// ALPNResult is how the server took a connection offering just one protocol.
+type ALPNResult struct {
+	Proto string
+	Err   error // nil if the server chose the protocol
+}
+
+// errNoProtocol is for servers that finish the handshake without choosing a protocol, usually because they don't do
+// ALPN at all.
+var errNoProtocol = errors.New("server chose no protocol")
+
+// Required tells whether the server turned away a client without a cert.
+func (p ClientAuthProbe) Required() bool {
+	return p.WithoutCert != nil
+}
+
+func newCertificateRequest(cri *tls.CertificateRequestInfo) *CertificateRequest {
+	cr := CertificateRequest{SignatureSchemes: cri.SignatureSchemes}
+	for _, der := range cri.AcceptableCAs {
+		var rdns pkix.RDNSequence
+		if _, err := asn1.Unmarshal(der, &rdns); err != nil {
+			cr.AcceptableCAs = append(cr.AcceptableCAs, fmt.Sprintf("invalid name: %x", der))
+			continue
+		}
+		var name pkix.Name
+		name.FillFromRDNSequence(&rdns)
+		cr.AcceptableCAs = append(cr.AcceptableCAs, name.String())
+	}
+	return &cr
+}
+
+// selectCertificate picks the first cert the server will accept, going by the CAs and signature schemes in its
+// CertificateRequest, or just the first one if told to.
+func (c *Client) selectCertificate(cri *tls.CertificateRequestInfo) *namedCertificate {
+	for i := range c.certs {
+		if c.sendFirst || cri.SupportsCertificate(&c.certs[i].cert) == nil {
+			return &c.certs[i]
+		}
+	}
+	return nil
 }
 
 func (c *Client) GatherListenerInfo() (TLSListenerInfo, error) {
-	conn, err := tls.Dial("tcp", c.Addr, c.TLSConfig)
-	if err != nil {
-		return TLSListenerInfo{}, err
+	info := TLSListenerInfo{Addr: c.Addr}
+
+	tc := c.TLSConfig.Clone()
+	tc.GetClientCertificate = func(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		info.CertRequest = newCertificateRequest(cri)
+		if nc := c.selectCertificate(cri); nc != nil {
+			info.ClientCert = nc.name
+			return &nc.cert, nil
+		}
+		return &tls.Certificate{}, nil
 	}
 
-	err = conn.Handshake()
+	conn, err := tls.Dial("tcp", c.Addr, tc)
 	if err != nil {
-		_ = conn.Close()
-		return TLSListenerInfo{}, err
+		// before TLS 1.3, the server turns away a client cert during the handshake
+		if c.probe && info.CertRequest != nil {
+			info.Probe = c.probeClientAuth()
+		}
+		return info, err
 	}
 
 	cs := conn.ConnectionState()
 	err = conn.Close()
 
-	return TLSListenerInfo{
-		Addr:        c.Addr,
-		TLSVersion:  cs.Version,
-		PeerCerts:   cs.PeerCertificates,
-		CipherSuite: cs.CipherSuite,
-	}, err
+	info.TLSVersion = cs.Version
+	info.PeerCerts = cs.PeerCertificates
+	info.CipherSuite = cs.CipherSuite
+	info.ALPN = cs.NegotiatedProtocol
+
+	if c.probe && info.CertRequest != nil {
+		info.Probe = c.probeClientAuth()
+	}
+	for _, p := range c.probeALPN {
+		info.ALPNProbe = append(info.ALPNProbe, ALPNResult{Proto: p, Err: c.tryALPN(p)})
+	}
+	return info, err
+}
+
+// probeClientAuth connects without a client cert, and then with one if there's one to send. In TLS 1.3 the server
+// only rejects a client cert after the client's side of the handshake is done, so each attempt waits a moment for an
+// alert.
+func (c *Client) probeClientAuth() *ClientAuthProbe {
+	var p ClientAuthProbe
+	p.WithoutCert = c.tryClientCert(func(*tls.CertificateRequestInfo) *namedCertificate { return nil })
+
+	p.WithCert = c.tryClientCert(func(cri *tls.CertificateRequestInfo) *namedCertificate {
+		nc := c.selectCertificate(cri)
+		if nc != nil {
+			p.SentCert = nc.name
+		}
+		return nc
+	})
+	if p.SentCert == "" {
+		p.WithCert = nil
+	}
+	return &p
+}
+
+func (c *Client) tryClientCert(choose func(*tls.CertificateRequestInfo) *namedCertificate) error {
+	tc := c.TLSConfig.Clone()
+	tc.GetClientCertificate = func(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		if nc := choose(cri); nc != nil {
+			return &nc.cert, nil
+		}
+		return &tls.Certificate{}, nil
+	}
+
+	conn, err := tls.Dial("tcp", c.Addr, tc)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	err = conn.SetReadDeadline(time.Now().Add(probeTimeout))
+	if err != nil {
+		return err
+	}
+	_, err = conn.Read(make([]byte, 1))
+	switch {
+	case err == nil, errors.Is(err, io.EOF), errors.Is(err, os.ErrDeadlineExceeded):
+		// the server sent data, hung up, or had nothing to say: the cert was accepted
+		return nil
+	case isRemoteAlert(err):
+		return err
+	default:
+		return fmt.Errorf("waiting for the server: %w", err)
+	}
+}
+
+// isRemoteAlert tells whether err is an alert the server sent. crypto/tls reports those as a *net.OpError whose Op is
+// "remote error".
+func isRemoteAlert(err error) bool {
+	var opErr *net.OpError
+	return errors.As(err, &opErr) && opErr.Op == "remote error"
+}
+
+// tryALPN connects offering just the one protocol, and a client cert if the server asks for one.
+func (c *Client) tryALPN(proto string) error {
+	tc := c.TLSConfig.Clone()
+	tc.NextProtos = []string{proto}
+	tc.GetClientCertificate = func(cri *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		if nc := c.selectCertificate(cri); nc != nil {
+			return &nc.cert, nil
+		}
+		return &tls.Certificate{}, nil
+	}
+
+	conn, err := tls.Dial("tcp", c.Addr, tc)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if conn.ConnectionState().NegotiatedProtocol != proto {
+		return errNoProtocol
+	}
+	return nil
 }
@@ -0,0 +1,145 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/pki"
+)
+
+func TestClient_GatherListenerInfo_clientCerts(t *testing.T) {
+	store, err := pki.NewStoreFromConfig(map[string]config.Cert{
+		"ca":       {KeyType: "P-256", Purpose: "root-ca"},
+		"other-ca": {KeyType: "P-256", Purpose: "root-ca"},
+		"server":   {KeyType: "P-256", Parent: "ca", DNSNames: []string{"localhost"}},
+		"other":    {KeyType: "P-256", Parent: "other-ca", Purpose: "client"},
+		"client":   {KeyType: "P-256", Parent: "ca", Purpose: "client"},
+	})
+	assert.Nil(t, err)
+
+	cas := x509.NewCertPool()
+	cas.AddCert(store["ca"].GetCertificate())
+	addr := listen(t, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: store["server"].GetCertChainDER(), PrivateKey: store["server"].GetPrivateKey()}},
+		ClientAuth:   tls.VerifyClientCertIfGiven,
+		ClientCAs:    cas,
+	})
+
+	for selection, expected := range map[string]string{"auto": "client", "first": "other"} {
+		c, err := NewClientFromConfig(config.Client{Addr: addr, Certs: []string{"other", "client"}, CertSelection: selection}, store)
+		assert.Nil(t, err)
+		info, err := c.GatherListenerInfo()
+		if assert.NotNil(t, info.CertRequest) {
+			assert.Equal(t, []string{store["ca"].GetCertificate().Subject.String()}, info.CertRequest.AcceptableCAs)
+			assert.NotEmpty(t, info.CertRequest.SignatureSchemes)
+		}
+		assert.Equal(t, expected, info.ClientCert, selection)
+		if selection == "auto" {
+			assert.Nil(t, err)
+		}
+	}
+}
+
+func TestClient_GatherListenerInfo_probe(t *testing.T) {
+	store, err := pki.NewStoreFromConfig(map[string]config.Cert{
+		"ca":     {KeyType: "P-256", Purpose: "root-ca"},
+		"server": {KeyType: "P-256", Parent: "ca", DNSNames: []string{"localhost"}},
+		"client": {KeyType: "P-256", Parent: "ca", Purpose: "client"},
+	})
+	assert.Nil(t, err)
+
+	cas := x509.NewCertPool()
+	cas.AddCert(store["ca"].GetCertificate())
+	addr := listen(t, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: store["server"].GetCertChainDER(), PrivateKey: store["server"].GetPrivateKey()}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    cas,
+	})
+
+	c, err := NewClientFromConfig(config.Client{Addr: addr, Certs: []string{"client"}, ProbeClientAuth: true}, store)
+	assert.Nil(t, err)
+	info, err := c.GatherListenerInfo()
+	assert.Nil(t, err)
+	if assert.NotNil(t, info.Probe) {
+		assert.True(t, info.Probe.Required())
+		assert.Equal(t, "client", info.Probe.SentCert)
+		assert.Nil(t, info.Probe.WithCert)
+	}
+}
+
+func TestClient_GatherListenerInfo_probeAfterFailure(t *testing.T) {
+	store, err := pki.NewStoreFromConfig(map[string]config.Cert{
+		"ca":       {KeyType: "P-256", Purpose: "root-ca"},
+		"other-ca": {KeyType: "P-256", Purpose: "root-ca"},
+		"server":   {KeyType: "P-256", Parent: "ca", DNSNames: []string{"localhost"}},
+		"other":    {KeyType: "P-256", Parent: "other-ca", Purpose: "client"},
+	})
+	assert.Nil(t, err)
+
+	// in TLS 1.2 the server turns the cert away during the handshake
+	cas := x509.NewCertPool()
+	cas.AddCert(store["ca"].GetCertificate())
+	addr := listen(t, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: store["server"].GetCertChainDER(), PrivateKey: store["server"].GetPrivateKey()}},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    cas,
+		MaxVersion:   tls.VersionTLS12,
+	})
+
+	c, err := NewClientFromConfig(config.Client{Addr: addr, Certs: []string{"other"}, CertSelection: "first", ProbeClientAuth: true}, store)
+	assert.Nil(t, err)
+	info, err := c.GatherListenerInfo()
+	assert.True(t, isRemoteAlert(err), err)
+	if assert.NotNil(t, info.Probe) {
+		assert.True(t, isRemoteAlert(info.Probe.WithoutCert), info.Probe.WithoutCert)
+		assert.Equal(t, "other", info.Probe.SentCert)
+		assert.True(t, isRemoteAlert(info.Probe.WithCert), info.Probe.WithCert)
+	}
+}
+
+func TestClient_GatherListenerInfo_alpnProbe(t *testing.T) {
+	store, err := pki.NewStoreFromConfig(map[string]config.Cert{
+		"ca":     {KeyType: "P-256", Purpose: "root-ca"},
+		"server": {KeyType: "P-256", Parent: "ca", DNSNames: []string{"localhost"}},
+	})
+	assert.Nil(t, err)
+
+	addr := listen(t, &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: store["server"].GetCertChainDER(), PrivateKey: store["server"].GetPrivateKey()}},
+		NextProtos:   []string{"h2", "http/1.1"},
+	})
+
+	c, err := NewClientFromConfig(config.Client{Addr: addr, ALPN: []string{"http/1.1", "h2"}, ProbeALPN: []string{"h2", "http/1.1", "acme-tls/1"}}, store)
+	assert.Nil(t, err)
+	info, err := c.GatherListenerInfo()
+	assert.Nil(t, err)
+	assert.Equal(t, "h2", info.ALPN)
+	if assert.Len(t, info.ALPNProbe, 3) {
+		assert.Nil(t, info.ALPNProbe[0].Err)
+		assert.Nil(t, info.ALPNProbe[1].Err)
+		assert.ErrorContains(t, info.ALPNProbe[2].Err, "no application protocol")
+	}
+}
+
+// listen accepts TLS connections until the test ends, and completes their handshakes.
+func listen(t *testing.T, tc *tls.Config) string {
+	l, err := tls.Listen("tcp", "127.0.0.1:0", tc)
+	assert.Nil(t, err)
+	t.Cleanup(func() { _ = l.Close() })
+
+	go func() {
+		for {
+			c, err := l.Accept()
+			if err != nil {
+				return
+			}
+			_, _ = c.Read(nil)
+			_ = c.Close()
+		}
+	}()
+	return l.Addr().String()
+}
@@ -120,8 +120,13 @@ func (c Cert) ToTemplate() (*x509.Certificate, error) {
 	}
 
 	tmpl.OCSPServer = c.OCSPServer
+	tmpl.IssuingCertificateURL = c.IssuingCertificateURL
 	tmpl.CRLDistributionPoints = c.CRLDistributionPoints
 
+	if c.MustStaple {
+		tmpl.ExtraExtensions = append(tmpl.ExtraExtensions, mustStapleExtension)
+	}
+
 	if c.Issuer != nil {
 		tmpl.Issuer, err = c.Issuer.ToPkixName()
 		if err != nil {
@@ -150,6 +155,12 @@ func (c Cert) ToTemplate() (*x509.Certificate, error) {
 	return &tmpl, nil
 }
 
+// TLSFeature ::= SEQUENCE OF INTEGER, containing status_request (5)
+var mustStapleExtension = pkix.Extension{
+	Id:    asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24},
+	Value: []byte{0x30, 0x03, 0x02, 0x01, 0x05},
+}
+
 func (c Cert) GetKeyType() string {
 	if c.KeyType == "" {
 		return DefaultKeyType
@@ -208,6 +219,16 @@ func parseKeyUsage(s string) (x509.KeyUsage, error) {
 	return 0, errors.New("invalid key usage")
 }
 
+// ExtKeyUsageName returns the config name of an extended key usage, or its number if it has none.
+func ExtKeyUsageName(eku x509.ExtKeyUsage) string {
+	for name, u := range extKeyUsages {
+		if u == eku {
+			return name
+		}
+	}
+	return strconv.Itoa(int(eku))
+}
+
 func parseExtKeyUsage(s string) (x509.ExtKeyUsage, error) {
 	eku, ok := extKeyUsages[strings.ToLower(s)]
 	if ok {
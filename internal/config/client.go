@@ -35,5 +35,7 @@ func (c Client) ToTLSConfig() (*tls.Config, error) {
 		}
 	}
 
+	tc.NextProtos = append(tc.NextProtos, c.ALPN...)
+
 	return &tc, nil
 }
@@ -3,12 +3,29 @@ package config
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"path/filepath"
 )
 
 type Config struct {
-	Certs     map[string]Cert     `json:"certs"`
-	Clients   []Client            `json:"clients"`
-	Listeners map[string]Listener `json:"listeners"`
+	Certs     map[string]Cert       `json:"certs"`
+	Clients   []Client              `json:"clients"`
+	Listeners map[string]Listener   `json:"listeners"`
+	ACME      map[string]ACMEServer `json:"acme"`
+	HTTP      map[string]HTTPServer `json:"http"`
+	Output    Output                `json:"output"` // where mkcerts writes files
+	State     string                `json:"state"`  // directory where keys and certs are kept between runs; default: .mkcerts next to the config file
+}
+
+// DefaultStateDir is where every command keeps its state, next to the config file, unless the config says otherwise, so
+// that they all use the same keys and certs.
+const DefaultStateDir = ".mkcerts"
+
+// StateDir returns the config's state directory, or the default one for the given config file.
+func (c Config) StateDir(configFile string) string {
+	if c.State != "" {
+		return c.State
+	}
+	return filepath.Join(filepath.Dir(configFile), DefaultStateDir)
 }
 
 const DefaultKeyType = "RSA-2048"
@@ -21,6 +38,7 @@ type Cert struct {
 	Parent    string   `json:"parent"`    // default: self (self-signed)
 	NotBefore string   `json:"notBefore"` // default: now
 	NotAfter  string   `json:"notAfter"`  // default: now + 375 days
+	CSR       string   `json:"csr"`       // sign the public key from this PKCS#10 file instead of generating a key
 
 	// subject alternative names
 	DNSNames       []string `json:"hostnames"`
@@ -30,36 +48,237 @@ type Cert struct {
 
 	// advanced options
 	OCSPServer            []string             `json:"ocspServer"`
+	IssuingCertificateURL []string             `json:"caIssuers"`
 	CRLDistributionPoints []string             `json:"crls"`
 	CA                    bool                 `json:"ca"`
+	MustStaple            bool                 `json:"mustStaple"` // TLS feature extension requiring a stapled OCSP response
 	MaxPathLen            *int                 `json:"maxPathLen"`
 	SignatureAlg          string               `json:"signatureAlg"`
 	KeyUsage              *string              `json:"keyUsage"`
 	ExtKeyUsage           *string              `json:"extendedKeyUsage"`
 	Extensions            map[string]Extension `json:"extensions"`
+	Serials               *SerialPolicy        `json:"serials"`   // serials of issued certs; default: 18 random bytes
+	Request               *Request             `json:"request"`   // also write a PKCS#10 request for this cert
+	Revoked               *Revocation          `json:"revoked"`   // list this cert on its issuer's CRL
+	CRL                   *CRLOptions          `json:"crl"`       // CAs only: options for the CRL this CA issues
+	PKCS12                *PKCS12              `json:"pkcs12"`    // also write a PKCS#12 file with this cert and its chain
+	JKS                   *JKS                 `json:"jks"`       // also write a Java keystore with this key and its chain
+	KeyFormat             *KeyFormat           `json:"keyFormat"` // how this cert's key is written; default: the output's keyFormat
 
 	// options for when you want to break things
 	SerialNumber   *HexString `json:"serial"`
+	SameSerialAs   string     `json:"sameSerialAs"` // reuse the serial of another cert from the same issuer
 	SubjectKeyId   *HexString `json:"ski"`
 	Issuer         *Subject   `json:"issuer"`
 	AuthorityKeyId *HexString `json:"aki"`
 }
 
 type Client struct {
-	Addr          string   `json:"addr"`
-	Verify        bool     `json:"verify"`
-	MinTLSVersion string   `json:"minTLSVersion"` // default: 1.0
-	MaxTLSVersion string   `json:"maxTLSVersion"` // default: 1.3
-	CipherSuites  *string  `json:"cipherSuites"`
-	Certs         []string `json:"certs"`
+	Addr            string   `json:"addr"`
+	Verify          bool     `json:"verify"`
+	MinTLSVersion   string   `json:"minTLSVersion"` // default: 1.0
+	MaxTLSVersion   string   `json:"maxTLSVersion"` // default: 1.3
+	CipherSuites    *string  `json:"cipherSuites"`
+	Certs           []string `json:"certs"`           // client certs, offered when the server asks for one
+	CertSelection   string   `json:"certSelection"`   // "auto" (default: the first the server's CAs accept) or "first" (always)
+	ProbeClientAuth bool     `json:"probeClientAuth"` // connect again without a client cert, then with one, to see which the server requires
+	ALPN            []string `json:"alpn"`            // protocols to offer, in order of preference; default: none
+	ProbeALPN       []string `json:"probeAlpn"`       // connect again offering each of these alone, to see which the server accepts
 }
 
 type Listener struct {
-	Certs         []string          `json:"certs"`
-	SniOverrides  map[string]string `json:"sniOverrides"`
-	MinTLSVersion string            `json:"minTLSVersion"` // default: 1.0
-	MaxTLSVersion string            `json:"maxTLSVersion"` // default: 1.3
-	CipherSuites  *string           `json:"cipherSuites"`
+	Certs            []string            `json:"certs"`
+	SniOverrides     map[string]string   `json:"sniOverrides"`     // cert names by server name, which may be a wildcard like *.example.com
+	NoSNICert        string              `json:"noSniCert"`        // cert for clients that don't send SNI; default: one of certs
+	RejectUnknownSNI bool                `json:"rejectUnknownSni"` // abort with an unrecognized_name alert if no cert matches the name
+	MinTLSVersion    string              `json:"minTLSVersion"`    // default: 1.0
+	MaxTLSVersion    string              `json:"maxTLSVersion"`    // default: 1.3
+	CipherSuites     *string             `json:"cipherSuites"`
+	ACME             *ACMEClient         `json:"acme"`         // obtain a cert from an ACME directory; certs are used until then
+	Stapling         *Stapling           `json:"stapling"`     // staple an OCSP response to each cert, generated again halfway to its nextUpdate
+	VirtualHosts     map[string]Listener `json:"virtualHosts"` // by server name, like sniOverrides; certs default to the listener's
+	ClientAuth       *ClientAuth         `json:"clientAuth"`   // ask clients for certs
+	ALPN             []string            `json:"alpn"`         // protocols to accept, in order of preference; default: none, or h2 and http/1.1 for the HTTP apps
+	RequireALPN      bool                `json:"requireAlpn"`  // turn away clients whose protocols don't match the listener's
+	App              string              `json:"app"`          // what to do after the handshake: "discard" (default), "echo", "http" or "static"
+	StaticDir        string              `json:"staticDir"`    // directory served by the static app
+}
+
+// ClientAuth asks clients for certs. In the verifying modes, a cert has to chain to one of the CAs and, unless other
+// extended key usages are required, have the clientAuth one. The other checks apply to every cert a client sends.
+type ClientAuth struct {
+	Mode         string   `json:"mode"`              // "none" (default), "request", "require", "verify-if-given" or "require-and-verify"
+	CAs          []string `json:"cas"`               // certs trusted to issue client certs; default: every root
+	ExtKeyUsages *string  `json:"extendedKeyUsages"` // comma-separated; the client cert needs all of them
+	Subjects     []string `json:"subjects"`          // glob patterns for the CN or the whole subject; default: any subject
+	CheckCRLs    bool     `json:"checkCrls"`         // reject client certs on their issuer's CRL, or whose issuer has none
+}
+
+type Stapling struct {
+	Response OCSPResponse `json:"response"` // status and validity window of the stapled responses
+
+	// options for when you want to break things
+	WrongCert string   `json:"wrongCert"` // staple the response for this cert instead
+	Omit      []string `json:"omit"`      // certs to staple nothing for, e.g. must-staple ones
+}
+
+type ACMEClient struct {
+	Directory   string   `json:"directory"`   // directory URL
+	Domains     []string `json:"domains"`     // names requested in the order
+	Email       string   `json:"email"`       // account contact
+	Challenge   string   `json:"challenge"`   // "tls-alpn-01" (default, answered by the listener) or "http-01"
+	HTTPAddr    string   `json:"httpAddr"`    // address for answering http-01 challenges; default: :80
+	KeyType     string   `json:"keyType"`     // default: P-256
+	RenewBefore string   `json:"renewBefore"` // e.g. "720h"; default: a third of the cert's lifetime
+	Insecure    bool     `json:"insecure"`    // don't verify the directory's HTTPS cert
+}
+
+type SerialPolicy struct {
+	Type     string     `json:"type"`     // "random" (default) or "sequential"
+	Start    *HexString `json:"start"`    // sequential only; default: 1
+	Length   int        `json:"length"`   // random only, in bytes; default: 18
+	HighBit  bool       `json:"highBit"`  // random only; set the most significant bit
+	Negative bool       `json:"negative"` // random only; set the high bit and encode without a leading zero
+}
+
+type Request struct {
+	ChallengePassword *string `json:"challengePassword"`
+
+	// options for when you want to break things
+	BadSignature bool `json:"badSignature"`
+	EmptySubject bool `json:"emptySubject"`
+}
+
+type PKCS12 struct {
+	Password     *string `json:"password"`     // default: changeit
+	Encryption   string  `json:"encryption"`   // "modern" (AES-256, PBKDF2, SHA-256 MAC; default) or "legacy" (RC2/3DES, SHA-1 MAC)
+	Iterations   int     `json:"iterations"`   // for key derivation and the MAC; default: 2048
+	FriendlyName string  `json:"friendlyName"` // default: the cert's name
+	TrustOnly    bool    `json:"trustOnly"`    // just the cert and its chain, marked as trusted, without the key
+}
+
+type JKS struct {
+	Format   string  `json:"format"`   // "jks" (default) or "jceks"
+	Password *string `json:"password"` // for the store and its keys; default: changeit
+	Alias    string  `json:"alias"`    // text/template with .Name and .Slug; default: "{{.Slug}}"
+}
+
+// KeyFormat chooses the encoding of key files. With a password, PKCS#8 keys are encrypted with PBES2 (PBKDF2 with
+// HMAC-SHA256), PKCS#1 and SEC 1 keys use legacy OpenSSL encryption (Proc-Type and DEK-Info headers, which ignore
+// iterations) and OpenSSH keys use bcrypt with AES-256-CTR (which ignores cipher and iterations). Single DES
+// (des-cbc) is only there for the legacy encryption, to test old readers; PBES2 refuses it.
+type KeyFormat struct {
+	Format     string  `json:"format"`     // "pkcs8" (default), "pkcs1" (RSA only), "sec1" (ECDSA only) or "openssh"
+	Password   *string `json:"password"`   // default: not encrypted
+	Cipher     string  `json:"cipher"`     // aes-128-cbc, aes-192-cbc, aes-256-cbc (default), des-ede3-cbc or des-cbc
+	Iterations int     `json:"iterations"` // PBKDF2 iterations; default: 2048
+}
+
+type TrustStore struct {
+	File string `json:"file"`
+	JKS
+}
+
+type PKCS12TrustStore struct {
+	File string `json:"file"`
+	PKCS12
+}
+
+type ACMEServer struct {
+	Issuer      string      `json:"issuer"`      // CA that signs issued certs
+	Cert        string      `json:"cert"`        // cert for the HTTPS listener
+	Profile     string      `json:"profile"`     // cert used as a template for issued certs; default: server
+	AutoApprove bool        `json:"autoApprove"` // mark challenges valid without checking them
+	HTTPPort    int         `json:"httpPort"`    // port for http-01 validation; default: 80
+	TLSPort     int         `json:"tlsPort"`     // port for tls-alpn-01 validation; default: 443
+	DNSResolver string      `json:"dnsResolver"` // DNS server for dns-01 validation; default: system resolver
+	Errors      *ACMEErrors `json:"errors"`
+}
+
+type ACMEErrors struct {
+	BadNonce        float64 `json:"badNonce"`        // fraction of requests rejected with badNonce
+	RateLimited     float64 `json:"rateLimited"`     // fraction of new orders rejected with rateLimited
+	ValidationDelay string  `json:"validationDelay"` // wait before validating challenges, e.g. "5s"
+}
+
+type Revocation struct {
+	Time           string `json:"time"`           // default: the cert's notBefore
+	Reason         string `json:"reason"`         // name (e.g. keyCompromise) or number; default: none
+	InvalidityDate string `json:"invalidityDate"` // default: none
+}
+
+type CRLOptions struct {
+	ThisUpdate            string   `json:"thisUpdate"`  // default: now
+	NextUpdate            string   `json:"nextUpdate"`  // default: thisUpdate + 7 days
+	Number                *int64   `json:"number"`      // default: 1
+	BaseCRLNumber         *int64   `json:"baseCRL"`     // make this a delta CRL for the given base CRL number
+	DistributionPoints    []string `json:"idp"`         // issuing distribution point URIs
+	OnlyContainsUserCerts bool     `json:"idpUserOnly"` // issuing distribution point flags
+	OnlyContainsCACerts   bool     `json:"idpCAOnly"`
+
+	// options for when you want to break things
+	Signer       string `json:"signer"` // sign with this cert's key instead of the CA's
+	OmitAKI      bool   `json:"omitAki"`
+	BadSignature bool   `json:"badSignature"`
+}
+
+// HTTPServer serves the CRL and certificate of every CA at /crl/<name>.crl and /certs/<name>.crt, where <name> is
+// the lowercased name of the cert with runs of other characters replaced by dashes.
+type HTTPServer struct {
+	Faults map[string]HTTPFault `json:"faults"` // by cert name, or "*" for every cert
+	OCSP   *OCSPResponder       `json:"ocsp"`   // also answer OCSP requests (GET or POST) at /ocsp
+}
+
+type HTTPFault struct {
+	Status      int    `json:"status"`      // respond with this status and no content, e.g. 404
+	Delay       string `json:"delay"`       // wait before responding, e.g. "10s"
+	ContentType string `json:"contentType"` // override the Content-Type header
+	PEM         bool   `json:"pem"`         // serve PEM instead of DER
+	Stale       bool   `json:"stale"`       // serve a CRL whose nextUpdate has passed
+}
+
+// OCSPResponder answers for every cert in the store. Responses are signed by the issuing CA unless it has a delegate.
+type OCSPResponder struct {
+	Delegates map[string]string       `json:"delegates"` // by CA name: responder cert (with the ocspSigning EKU) that signs for it
+	Responses map[string]OCSPResponse `json:"responses"` // by cert name, or "*" for every cert
+}
+
+type OCSPResponse struct {
+	Status     string `json:"status"`     // "good", "revoked", "unknown", "tryLater" or "unauthorized"; default: from the config
+	ThisUpdate string `json:"thisUpdate"` // default: now
+	NextUpdate string `json:"nextUpdate"` // default: thisUpdate + 1 day
+	Responder  string `json:"responder"`  // default: the CA's delegate, or the CA itself
+
+	// options for when you want to break things
+	Expired      bool   `json:"expired"` // nextUpdate has passed
+	Signer       string `json:"signer"`  // sign with this cert's key instead of the responder's
+	BadSignature bool   `json:"badSignature"`
+}
+
+// Output controls the files written by mkcerts. File names come from a text/template with .Name (the cert's name),
+// .Slug (the name made safe for file names), .Kind (key, crt, crl, csr, chain, fullchain, combined, p12, jks, key-der,
+// crt-der, p7b or p7b-pem) and .Ext (the usual extension for the kind, e.g. "fullchain.pem").
+type Output struct {
+	Dir              string            `json:"dir"`              // default: current directory
+	Filenames        string            `json:"filenames"`        // relative to dir; default: "{{.Slug}}.{{.Ext}}"
+	Chain            bool              `json:"chain"`            // write the intermediates of each issued cert
+	FullChain        bool              `json:"fullChain"`        // write each cert followed by its intermediates
+	Combined         bool              `json:"combined"`         // write each key followed by its cert
+	DER              bool              `json:"der"`              // also write each cert and key in DER
+	PKCS7            bool              `json:"pkcs7"`            // write each cert and its chain as a PKCS#7 bundle, in DER and PEM
+	Roots            string            `json:"roots"`            // file for a bundle of every root cert; default: none
+	Manifest         string            `json:"manifest"`         // file for a JSON list of the files, fingerprints, serials and issuers; default: none
+	TrustStore       *TrustStore       `json:"trustStore"`       // Java truststore with every root cert; default: none
+	PKCS12TrustStore *PKCS12TrustStore `json:"pkcs12TrustStore"` // PKCS#12 file with every root cert, marked as trusted; default: none
+	KeyFormat        *KeyFormat        `json:"keyFormat"`        // how keys are written; default: unencrypted PKCS#8
+	HashedDir        *HashedDir        `json:"hashedDir"`        // CA certs named by subject hash, for -CApath; default: none
+}
+
+// HashedDir is a directory like the ones c_rehash makes: each CA cert is in <hash>.0, where <hash> is OpenSSL's subject
+// hash, with .1, .2 and so on for collisions. CRLs go in <hash>.r0 and so on, hashed by issuer.
+type HashedDir struct {
+	Dir  string `json:"dir"`  // relative to the output dir
+	CRLs bool   `json:"crls"` // also write the CAs' CRLs
 }
 
 type Subject struct {
@@ -0,0 +1,14 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfig_StateDir(t *testing.T) {
+	assert.Equal(t, DefaultStateDir, Config{}.StateDir("certs.conf"))
+	assert.Equal(t, filepath.Join("conf", DefaultStateDir), Config{}.StateDir(filepath.Join("conf", "server.conf")))
+	assert.Equal(t, "state", Config{State: "state"}.StateDir(filepath.Join("conf", "server.conf")))
+}
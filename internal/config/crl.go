@@ -0,0 +1,200 @@
+package config
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultCRLLifetime = 7 * 24 * time.Hour
+
+var (
+	oidExtensionReasonCode               = asn1.ObjectIdentifier{2, 5, 29, 21}
+	oidExtensionInvalidityDate           = asn1.ObjectIdentifier{2, 5, 29, 24}
+	oidExtensionDeltaCRLIndicator        = asn1.ObjectIdentifier{2, 5, 29, 27}
+	oidExtensionIssuingDistributionPoint = asn1.ObjectIdentifier{2, 5, 29, 28}
+)
+
+func (c CRLOptions) ToTemplate() (*x509.RevocationList, error) {
+	tmpl := x509.RevocationList{
+		Number: big.NewInt(1),
+	}
+
+	if c.ThisUpdate != "" {
+		t, err := parseTime(strings.TrimSpace(c.ThisUpdate))
+		if err != nil {
+			return nil, err
+		}
+		tmpl.ThisUpdate = t
+	} else {
+		tmpl.ThisUpdate = time.Now()
+	}
+
+	if c.NextUpdate != "" {
+		t, err := parseTime(strings.TrimSpace(c.NextUpdate))
+		if err != nil {
+			return nil, err
+		}
+		tmpl.NextUpdate = t
+	} else {
+		tmpl.NextUpdate = tmpl.ThisUpdate.Add(defaultCRLLifetime)
+	}
+
+	if c.Number != nil {
+		tmpl.Number = big.NewInt(*c.Number)
+	}
+
+	if c.BaseCRLNumber != nil {
+		v, err := asn1.Marshal(big.NewInt(*c.BaseCRLNumber))
+		if err != nil {
+			return nil, err
+		}
+		tmpl.ExtraExtensions = append(tmpl.ExtraExtensions, pkix.Extension{
+			Id:       oidExtensionDeltaCRLIndicator,
+			Critical: true,
+			Value:    v,
+		})
+	}
+
+	if len(c.DistributionPoints) > 0 || c.OnlyContainsUserCerts || c.OnlyContainsCACerts {
+		v, err := c.marshalIssuingDistributionPoint()
+		if err != nil {
+			return nil, err
+		}
+		tmpl.ExtraExtensions = append(tmpl.ExtraExtensions, pkix.Extension{
+			Id:       oidExtensionIssuingDistributionPoint,
+			Critical: true,
+			Value:    v,
+		})
+	}
+
+	return &tmpl, nil
+}
+
+//	IssuingDistributionPoint ::= SEQUENCE {
+//	    distributionPoint          [0] DistributionPointName OPTIONAL,
+//	    onlyContainsUserCerts      [1] BOOLEAN DEFAULT FALSE,
+//	    onlyContainsCACerts        [2] BOOLEAN DEFAULT FALSE,
+//	    ... }
+func (c CRLOptions) marshalIssuingDistributionPoint() ([]byte, error) {
+	var content []byte
+
+	if len(c.DistributionPoints) > 0 {
+		var names []byte
+		for _, uri := range c.DistributionPoints {
+			n, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 6, Bytes: []byte(uri)})
+			if err != nil {
+				return nil, err
+			}
+			names = append(names, n...)
+		}
+		fullName, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: names})
+		if err != nil {
+			return nil, err
+		}
+		dp, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: fullName})
+		if err != nil {
+			return nil, err
+		}
+		content = append(content, dp...)
+	}
+
+	for tag, flag := range []bool{1: c.OnlyContainsUserCerts, 2: c.OnlyContainsCACerts} {
+		if flag {
+			b, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, Bytes: []byte{0xff}})
+			if err != nil {
+				return nil, err
+			}
+			content = append(content, b...)
+		}
+	}
+
+	return asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: content})
+}
+
+// ToRevokedCertificate returns the CRL entry for a cert with the given serial number and notBefore.
+func (r Revocation) ToRevokedCertificate(serial *big.Int, notBefore time.Time) (pkix.RevokedCertificate, error) {
+	rc := pkix.RevokedCertificate{
+		SerialNumber: serial,
+	}
+
+	var err error
+	rc.RevocationTime, err = r.RevokedAt(notBefore)
+	if err != nil {
+		return rc, err
+	}
+
+	code, ok, err := r.ReasonCode()
+	if err != nil {
+		return rc, err
+	}
+	if ok {
+		v, err := asn1.Marshal(asn1.Enumerated(code))
+		if err != nil {
+			return rc, err
+		}
+		rc.Extensions = append(rc.Extensions, pkix.Extension{Id: oidExtensionReasonCode, Value: v})
+	}
+
+	if r.InvalidityDate != "" {
+		t, err := parseTime(strings.TrimSpace(r.InvalidityDate))
+		if err != nil {
+			return rc, err
+		}
+		v, err := asn1.MarshalWithParams(t.UTC(), "generalized")
+		if err != nil {
+			return rc, err
+		}
+		rc.Extensions = append(rc.Extensions, pkix.Extension{Id: oidExtensionInvalidityDate, Value: v})
+	}
+
+	return rc, nil
+}
+
+// RevokedAt returns the revocation time, which defaults to the cert's notBefore so that the CRL, OCSP responses and
+// later runs all agree on it.
+func (r Revocation) RevokedAt(notBefore time.Time) (time.Time, error) {
+	if r.Time == "" {
+		return notBefore, nil
+	}
+	return parseTime(strings.TrimSpace(r.Time))
+}
+
+// ReasonCode returns the CRLReason code, and whether there is one at all.
+func (r Revocation) ReasonCode() (int, bool, error) {
+	if r.Reason == "" {
+		return 0, false, nil
+	}
+	code, err := parseRevocationReason(r.Reason)
+	return code, err == nil, err
+}
+
+func parseRevocationReason(s string) (int, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if code, ok := revocationReasons[s]; ok {
+		return code, nil
+	}
+	code, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid revocation reason: %s", s)
+	}
+	return code, nil
+}
+
+var revocationReasons = map[string]int{
+	"unspecified":          0,
+	"keycompromise":        1,
+	"cacompromise":         2,
+	"affiliationchanged":   3,
+	"superseded":           4,
+	"cessationofoperation": 5,
+	"certificatehold":      6,
+	"removefromcrl":        8,
+	"privilegewithdrawn":   9,
+	"aacompromise":         10,
+}
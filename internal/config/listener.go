@@ -2,6 +2,7 @@ package config
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"errors"
 	"fmt"
 	"strings"
@@ -35,9 +36,51 @@ func (l Listener) ToTLSConfig() (*tls.Config, error) {
 		}
 	}
 
+	tc.NextProtos = append(tc.NextProtos, l.ALPN...)
+
+	if l.ClientAuth != nil {
+		tc.ClientAuth, err = l.ClientAuth.ToClientAuthType()
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	return &tc, nil
 }
 
+func (c ClientAuth) ToClientAuthType() (tls.ClientAuthType, error) {
+	switch strings.ToLower(strings.TrimSpace(c.Mode)) {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	case "require", "require-any":
+		return tls.RequireAnyClientCert, nil
+	case "verify-if-given":
+		return tls.VerifyClientCertIfGiven, nil
+	case "require-and-verify":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return 0, fmt.Errorf("invalid client auth mode: %s", c.Mode)
+	}
+}
+
+func (c ClientAuth) GetExtKeyUsages() ([]x509.ExtKeyUsage, error) {
+	if c.ExtKeyUsages == nil {
+		return nil, nil
+	}
+
+	var ekus []x509.ExtKeyUsage
+	for _, u := range strings.Split(*c.ExtKeyUsages, ",") {
+		eku, err := parseExtKeyUsage(strings.TrimSpace(u))
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", err, u)
+		}
+		ekus = append(ekus, eku)
+	}
+	return ekus, nil
+}
+
 func parseCipherSuite(s string) (*tls.CipherSuite, error) {
 	normalizedName := strings.ToUpper(strings.TrimSpace(s))
 	normalizedName = strings.ReplaceAll(normalizedName, "-", "_")
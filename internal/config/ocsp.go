@@ -0,0 +1,36 @@
+package config
+
+import (
+	"strings"
+	"time"
+)
+
+const defaultOCSPLifetime = 24 * time.Hour
+
+// Validity returns the thisUpdate and nextUpdate times of the response.
+func (o OCSPResponse) Validity() (time.Time, time.Time, error) {
+	if o.Expired {
+		now := time.Now()
+		return now.Add(-2 * defaultOCSPLifetime), now.Add(-defaultOCSPLifetime), nil
+	}
+
+	thisUpdate := time.Now()
+	if o.ThisUpdate != "" {
+		var err error
+		thisUpdate, err = parseTime(strings.TrimSpace(o.ThisUpdate))
+		if err != nil {
+			return thisUpdate, thisUpdate, err
+		}
+	}
+
+	nextUpdate := thisUpdate.Add(defaultOCSPLifetime)
+	if o.NextUpdate != "" {
+		var err error
+		nextUpdate, err = parseTime(strings.TrimSpace(o.NextUpdate))
+		if err != nil {
+			return thisUpdate, nextUpdate, err
+		}
+	}
+
+	return thisUpdate, nextUpdate, nil
+}
@@ -12,6 +12,13 @@ func parseTime(s string) (time.Time, error) {
 			return t, nil
 		}
 	}
+
+	// Relative to now, e.g. "-48h"
+	d, err := time.ParseDuration(s)
+	if err == nil {
+		return time.Now().Add(d), nil
+	}
+
 	return time.Now(), fmt.Errorf("invalid time format: %s", s)
 }
 
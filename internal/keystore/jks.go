@@ -0,0 +1,495 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf16"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/pki"
+	"tls-tools/internal/random"
+)
+
+const (
+	jksMagic   = 0xfeedfeed
+	jceksMagic = 0xcececece
+	jksVersion = 2
+
+	jksPrivateKeyTag  = 1
+	jksTrustedCertTag = 2
+
+	jksSaltLength   = 20
+	jceksSaltLength = 8
+	// What the JDK uses by default (jdk.jceks.iterationCount)
+	jceksIterations = 200000
+
+	defaultAlias = "{{.Slug}}"
+)
+
+var (
+	// Sun's proprietary key protection for JKS
+	oidJKSKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+	// PBEWithMD5AndTripleDES, used for keys in JCEKS
+	oidPBEWithMD5AndTripleDES = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 19, 1}
+)
+
+// JKSEntry is a private key (as PKCS#8) with its chain, or else a single trusted cert.
+type JKSEntry struct {
+	Alias      string
+	Date       time.Time
+	PrivateKey []byte
+	Certs      [][]byte
+}
+
+type jksOptions struct {
+	jceks    bool
+	password string
+	alias    *template.Template
+}
+
+func newJKSOptions(opts config.JKS) (jksOptions, error) {
+	o := jksOptions{password: DefaultPassword}
+	if opts.Password != nil {
+		o.password = *opts.Password
+	}
+
+	switch strings.ToLower(strings.TrimSpace(opts.Format)) {
+	case "", "jks":
+	case "jceks":
+		o.jceks = true
+	default:
+		return o, fmt.Errorf("invalid keystore format: %s", opts.Format)
+	}
+
+	alias := opts.Alias
+	if alias == "" {
+		alias = defaultAlias
+	}
+	var err error
+	o.alias, err = template.New("alias").Option("missingkey=error").Parse(alias)
+	if err != nil {
+		return o, fmt.Errorf("invalid alias template: %w", err)
+	}
+
+	return o, nil
+}
+
+// aliasFor renders the alias template. Java lowercases aliases, so this does too.
+func (o jksOptions) aliasFor(name string) (string, error) {
+	var b bytes.Buffer
+	err := o.alias.Execute(&b, struct{ Name, Slug string }{name, pki.Slug(name)})
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", name, err)
+	}
+	if b.Len() == 0 {
+		return "", fmt.Errorf("%s: empty alias", name)
+	}
+	return strings.ToLower(b.String()), nil
+}
+
+// NewJKS creates a Java keystore with a single entry: the named cert's key and chain.
+func NewJKS(name string, store pki.Store, opts config.JKS) ([]byte, error) {
+	kac, ok := store[name]
+	if !ok {
+		return nil, fmt.Errorf("failed to find cert named %s", name)
+	}
+	if kac.GetKeyDER() == nil {
+		return nil, fmt.Errorf("%s: cannot create a keystore without a private key", name)
+	}
+
+	o, err := newJKSOptions(opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+	alias, err := o.aliasFor(name)
+	if err != nil {
+		return nil, err
+	}
+
+	// The chain in a keystore goes all the way to the root
+	return o.encode([]JKSEntry{{
+		Alias:      alias,
+		Date:       time.Now(),
+		PrivateKey: kac.GetKeyDER(),
+		Certs:      kac.GetCertChainDER(),
+	}})
+}
+
+// NewJKSTrustStore creates a Java keystore with a trusted cert entry for each of the named certs.
+func NewJKSTrustStore(names []string, store pki.Store, opts config.JKS) ([]byte, error) {
+	o, err := newJKSOptions(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var entries []JKSEntry
+	seen := make(map[string]string)
+	for _, name := range names {
+		kac, ok := store[name]
+		if !ok {
+			return nil, fmt.Errorf("failed to find cert named %s", name)
+		}
+		alias, err := o.aliasFor(name)
+		if err != nil {
+			return nil, err
+		}
+		if other, ok := seen[alias]; ok {
+			return nil, fmt.Errorf("%s and %s have the same alias: %s", other, name, alias)
+		}
+		seen[alias] = name
+
+		entries = append(entries, JKSEntry{Alias: alias, Date: time.Now(), Certs: [][]byte{kac.GetCertDER()}})
+	}
+
+	return o.encode(entries)
+}
+
+func (o jksOptions) encode(entries []JKSEntry) ([]byte, error) {
+	var b bytes.Buffer
+	magic := uint32(jksMagic)
+	if o.jceks {
+		magic = jceksMagic
+	}
+	writeUint32(&b, magic)
+	writeUint32(&b, jksVersion)
+	writeUint32(&b, uint32(len(entries)))
+
+	for _, e := range entries {
+		tag := uint32(jksTrustedCertTag)
+		if e.PrivateKey != nil {
+			tag = jksPrivateKeyTag
+		}
+		writeUint32(&b, tag)
+		err := writeUTF(&b, e.Alias)
+		if err != nil {
+			return nil, err
+		}
+		writeUint64(&b, uint64(e.Date.UnixMilli()))
+
+		if e.PrivateKey == nil {
+			err = writeCert(&b, e.Certs[0])
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		protected, err := o.protectKey(e.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		writeUint32(&b, uint32(len(protected)))
+		b.Write(protected)
+		writeUint32(&b, uint32(len(e.Certs)))
+		for _, c := range e.Certs {
+			err = writeCert(&b, c)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	b.Write(jksDigest(o.password, b.Bytes()))
+	return b.Bytes(), nil
+}
+
+// ReadJKS parses a JKS or JCEKS keystore, checks its integrity and decrypts its keys.
+func ReadJKS(data []byte, password string) ([]JKSEntry, error) {
+	if len(data) < sha1.Size {
+		return nil, errors.New("keystore too short")
+	}
+	content, digest := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	if !bytes.Equal(digest, jksDigest(password, content)) {
+		return nil, errors.New("keystore password incorrect or keystore corrupted")
+	}
+
+	r := bytes.NewReader(content)
+	var header struct{ Magic, Version, Count uint32 }
+	err := binary.Read(r, binary.BigEndian, &header)
+	if err != nil {
+		return nil, err
+	}
+	if header.Magic != jksMagic && header.Magic != jceksMagic {
+		return nil, fmt.Errorf("not a Java keystore (magic %08x)", header.Magic)
+	}
+	if header.Version != jksVersion {
+		return nil, fmt.Errorf("unsupported keystore version: %d", header.Version)
+	}
+
+	var entries []JKSEntry
+	for i := uint32(0); i < header.Count; i++ {
+		var tag uint32
+		err = binary.Read(r, binary.BigEndian, &tag)
+		if err != nil {
+			return nil, err
+		}
+
+		var e JKSEntry
+		e.Alias, err = readUTF(r)
+		if err != nil {
+			return nil, err
+		}
+		var millis int64
+		err = binary.Read(r, binary.BigEndian, &millis)
+		if err != nil {
+			return nil, err
+		}
+		e.Date = time.UnixMilli(millis)
+
+		switch tag {
+		case jksTrustedCertTag:
+			c, err := readCert(r)
+			if err != nil {
+				return nil, err
+			}
+			e.Certs = [][]byte{c}
+		case jksPrivateKeyTag:
+			protected, err := readBytes(r)
+			if err != nil {
+				return nil, err
+			}
+			e.PrivateKey, err = recoverKey(protected, password)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", e.Alias, err)
+			}
+			var n uint32
+			err = binary.Read(r, binary.BigEndian, &n)
+			if err != nil {
+				return nil, err
+			}
+			for j := uint32(0); j < n; j++ {
+				c, err := readCert(r)
+				if err != nil {
+					return nil, err
+				}
+				e.Certs = append(e.Certs, c)
+			}
+		default:
+			return nil, fmt.Errorf("unsupported keystore entry type: %d", tag)
+		}
+
+		entries = append(entries, e)
+	}
+
+	if r.Len() > 0 {
+		return nil, errors.New("trailing data in keystore")
+	}
+	return entries, nil
+}
+
+// protectKey encrypts a PKCS#8 key the way JKS (Sun's key protector) or JCEKS (PBEWithMD5AndTripleDES) does, and
+// wraps it in an EncryptedPrivateKeyInfo.
+func (o jksOptions) protectKey(key []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+
+	if o.jceks {
+		salt := random.Bytes(jceksSaltLength)
+		params, err := asn1.Marshal(pbeParams{Salt: salt, Iterations: jceksIterations})
+		if err != nil {
+			return nil, err
+		}
+		block, iv, err := jceksCipher(o.password, salt, jceksIterations)
+		if err != nil {
+			return nil, err
+		}
+		info.Algorithm = pkix.AlgorithmIdentifier{Algorithm: oidPBEWithMD5AndTripleDES, Parameters: asn1.RawValue{FullBytes: params}}
+		info.EncryptedData = encryptCBC(block, iv, key)
+	} else {
+		password := javaPassword(o.password)
+		salt := random.Bytes(jksSaltLength)
+		check := sha1.Sum(append(append([]byte{}, password...), key...))
+		info.Algorithm = pkix.AlgorithmIdentifier{Algorithm: oidJKSKeyProtector, Parameters: asn1.NullRawValue}
+		info.EncryptedData = append(append(salt, jksKeystream(password, salt, key)...), check[:]...)
+	}
+
+	return asn1.Marshal(info)
+}
+
+func recoverKey(protected []byte, password string) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	_, err := asn1.Unmarshal(protected, &info)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case info.Algorithm.Algorithm.Equal(oidJKSKeyProtector):
+		data := info.EncryptedData
+		if len(data) < jksSaltLength+sha1.Size {
+			return nil, errors.New("protected key too short")
+		}
+		pw := javaPassword(password)
+		salt, encrypted, check := data[:jksSaltLength], data[jksSaltLength:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+		key := jksKeystream(pw, salt, encrypted)
+		sum := sha1.Sum(append(append([]byte{}, pw...), key...))
+		if !bytes.Equal(sum[:], check) {
+			return nil, errors.New("key password incorrect")
+		}
+		return key, nil
+
+	case info.Algorithm.Algorithm.Equal(oidPBEWithMD5AndTripleDES):
+		var params pbeParams
+		_, err = asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params)
+		if err != nil {
+			return nil, err
+		}
+		block, iv, err := jceksCipher(password, params.Salt, params.Iterations)
+		if err != nil {
+			return nil, err
+		}
+		data := info.EncryptedData
+		if len(data) == 0 || len(data)%block.BlockSize() != 0 {
+			return nil, errors.New("invalid protected key length")
+		}
+		key := make([]byte, len(data))
+		cipher.NewCBCDecrypter(block, iv).CryptBlocks(key, data)
+		pad := int(key[len(key)-1])
+		if pad == 0 || pad > block.BlockSize() || !bytes.Equal(key[len(key)-pad:], bytes.Repeat([]byte{byte(pad)}, pad)) {
+			return nil, errors.New("key password incorrect")
+		}
+		return key[:len(key)-pad], nil
+
+	default:
+		return nil, fmt.Errorf("unsupported key protection algorithm: %s", info.Algorithm.Algorithm)
+	}
+}
+
+// jksKeystream XORs data with SHA1(password || previous digest), starting from the salt.
+func jksKeystream(password, salt, data []byte) []byte {
+	out := make([]byte, len(data))
+	digest := salt
+	for i := 0; i < len(data); i += sha1.Size {
+		d := sha1.Sum(append(append([]byte{}, password...), digest...))
+		digest = d[:]
+		for j := 0; j < sha1.Size && i+j < len(data); j++ {
+			out[i+j] = data[i+j] ^ digest[j]
+		}
+	}
+	return out
+}
+
+// jceksCipher derives a 3DES key and IV from a password the way the JDK's PBEWithMD5AndTripleDES does: each half of
+// the salt is hashed with the password, iteratively, and the two results are concatenated.
+func jceksCipher(password string, salt []byte, iterations int) (cipher.Block, []byte, error) {
+	if len(salt) != jceksSaltLength {
+		return nil, nil, errors.New("invalid salt length")
+	}
+
+	// If the halves are equal, the JDK tries to reverse the first one, but it has a typo (salt[3-1] for salt[3-i])
+	s := append([]byte{}, salt...)
+	if bytes.Equal(s[:4], s[4:]) {
+		for i := 0; i < 2; i++ {
+			tmp := s[i]
+			s[i] = s[3-i]
+			s[2] = tmp
+		}
+	}
+
+	var derived []byte
+	for i := 0; i < 2; i++ {
+		h := s[i*4 : (i+1)*4]
+		for j := 0; j < iterations; j++ {
+			d := md5.Sum(append(append([]byte{}, h...), password...))
+			h = d[:]
+		}
+		derived = append(derived, h...)
+	}
+
+	block, err := des.NewTripleDESCipher(derived[:24])
+	return block, derived[24:], err
+}
+
+// jksDigest is the keystore's integrity check: SHA-1 over the password, a fixed phrase and the contents.
+func jksDigest(password string, content []byte) []byte {
+	h := sha1.New()
+	h.Write(javaPassword(password))
+	h.Write([]byte("Mighty Aphrodite"))
+	h.Write(content)
+	return h.Sum(nil)
+}
+
+// javaPassword encodes a password as UTF-16BE, without a terminator.
+func javaPassword(s string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(c>>8), byte(c))
+	}
+	return b
+}
+
+func writeUint32(b *bytes.Buffer, v uint32) {
+	_ = binary.Write(b, binary.BigEndian, v)
+}
+
+func writeUint64(b *bytes.Buffer, v uint64) {
+	_ = binary.Write(b, binary.BigEndian, v)
+}
+
+// writeUTF writes a string the way Java's DataOutputStream.writeUTF does. (Modified UTF-8 only differs for NUL and
+// supplementary characters, which don't belong in aliases anyway.)
+func writeUTF(b *bytes.Buffer, s string) error {
+	if len(s) > 0xffff {
+		return fmt.Errorf("string too long: %d bytes", len(s))
+	}
+	_ = binary.Write(b, binary.BigEndian, uint16(len(s)))
+	b.WriteString(s)
+	return nil
+}
+
+func readUTF(r io.Reader) (string, error) {
+	var n uint16
+	err := binary.Read(r, binary.BigEndian, &n)
+	if err != nil {
+		return "", err
+	}
+	s := make([]byte, n)
+	_, err = io.ReadFull(r, s)
+	return string(s), err
+}
+
+func writeCert(b *bytes.Buffer, der []byte) error {
+	err := writeUTF(b, "X.509")
+	if err != nil {
+		return err
+	}
+	writeUint32(b, uint32(len(der)))
+	b.Write(der)
+	return nil
+}
+
+func readCert(r *bytes.Reader) ([]byte, error) {
+	typ, err := readUTF(r)
+	if err != nil {
+		return nil, err
+	}
+	if typ != "X.509" {
+		return nil, fmt.Errorf("unsupported certificate type: %s", typ)
+	}
+	return readBytes(r)
+}
+
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	var n uint32
+	err := binary.Read(r, binary.BigEndian, &n)
+	if err != nil {
+		return nil, err
+	}
+	if int64(n) > int64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	return b, err
+}
@@ -0,0 +1,143 @@
+package keystore
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"tls-tools/internal/config"
+)
+
+func TestNewJKS(t *testing.T) {
+	store := newTestStore(t)
+	password := "secret"
+
+	for _, format := range []string{"jks", "jceks"} {
+		ks, err := NewJKS("leaf", store, config.JKS{Format: format, Password: &password, Alias: "{{.Name}}-Key"})
+		assert.Nil(t, err)
+
+		entries, err := ReadJKS(ks, password)
+		assert.Nil(t, err)
+		assert.Len(t, entries, 1)
+		assert.Equal(t, "leaf-key", entries[0].Alias)
+		assert.Equal(t, store["leaf"].GetKeyDER(), entries[0].PrivateKey)
+		assert.Equal(t, [][]byte{store["leaf"].GetCertDER(), store["ca"].GetCertDER()}, entries[0].Certs)
+
+		_, err = ReadJKS(ks, "wrong")
+		assert.NotNil(t, err)
+	}
+}
+
+func TestNewJKSTrustStore(t *testing.T) {
+	store := newTestStore(t)
+
+	ks, err := NewJKSTrustStore([]string{"ca", "leaf"}, store, config.JKS{})
+	assert.Nil(t, err)
+	assert.Equal(t, []byte{0xfe, 0xed, 0xfe, 0xed}, ks[:4])
+
+	entries, err := ReadJKS(ks, DefaultPassword)
+	assert.Nil(t, err)
+	assert.Len(t, entries, 2)
+	assert.Equal(t, "ca", entries[0].Alias)
+	assert.Nil(t, entries[0].PrivateKey)
+	assert.Equal(t, [][]byte{store["ca"].GetCertDER()}, entries[0].Certs)
+
+	_, err = NewJKSTrustStore([]string{"ca", "leaf"}, store, config.JKS{Alias: "same"})
+	assert.NotNil(t, err)
+	_, err = NewJKSTrustStore([]string{"ca"}, store, config.JKS{Alias: "{{if false}}x{{end}}"})
+	assert.ErrorContains(t, err, "empty alias")
+}
+
+// keytoolJCEKS is test-certs/example-elliptic-sha1.jceks from github.com/square/certigo v1.16.0, which its Makefile
+// makes with keytool -importkeystore (store and key password "password"): a P-521 key and its self-signed cert.
+const keytoolJCEKS = "zs7OzgAAAAIAAAABAAAAAQAVZXhhbXBsZS1lbGxpcHRpYy1zaGExAAABVXV9aNsAAAEbMIIBFzAaBgkrBgEEASoCEwEwDQQIJpfG" +
+	"dGBPnHoCARQEgfjlEDj37KX7H5gPv8+cMmeuVPtEvUn62iEAOuoas0NTyjent+hEohMhgNBpefM7W+ue1bzqxaK9Rs3f5KLfg2aQ" +
+	"ZofauyMhVU2UgklNqf2MKPbF2kyZyrUXPi78i94HabYQe00FERJ50zT1fHQFYJrK3iAd2SV3nQ3LDnr8yoWealhAtOoHWaz2V6C5" +
+	"mbGRRoac05qVDBPCHqAAfbv2FsECPOkcgkpvv755dEYFMB6cbW4VvLVJkQlv0ebFFAiXAXyUPsDU+V50F8tDMaIrNuVOLQ/A3nxv" +
+	"CADIGSQNCXKbZ/Z9CMPW9d1NsEf7P0cs5BlETKga06kFgQAAAAEABVguNTA5AAACjDCCAogwggHqoAMCAQICCQCf8YzCOhSjgDAJ" +
+	"BgcqhkjOPQQBMF4xCzAJBgNVBAYTAlVTMQswCQYDVQQIEwJDQTEQMA4GA1UEChMHY2VydGlnbzEQMA4GA1UECxMHZXhhbXBsZTEe" +
+	"MBwGA1UEAxMVZXhhbXBsZS1lbGxpcHRpYy1zaGExMB4XDTE2MDYyMjAwMjMyMFoXDTIzMDQyNzAwMjMyMFowXjELMAkGA1UEBhMC" +
+	"VVMxCzAJBgNVBAgTAkNBMRAwDgYDVQQKEwdjZXJ0aWdvMRAwDgYDVQQLEwdleGFtcGxlMR4wHAYDVQQDExVleGFtcGxlLWVsbGlw" +
+	"dGljLXNoYTEwgZswEAYHKoZIzj0CAQYFK4EEACMDgYYABAEQWNjT8/MemDSwPVJD6T0qDTPibCzuoIsk7iMEctY9jzfeG5j0WXL3" +
+	"3xTB6NIwW6H/7hBGsyZtFgKI5nKqhA+nGgDLvkHGKz3boBwhztZb+YsGebCcrMEPKsoGq6DFgYO0Gl4wLNaeDp3Lod+wsUY+9JrH" +
+	"tGBD7fp3gQ+QTPvGQ3IRV6NPME0wHQYDVR0lBBYwFAYIKwYBBQUHAwIGCCsGAQUFBwMBMCwGA1UdEQQlMCOHBH8AAAGHEAAAAAAA" +
+	"AAAAAAAAAAAAAAGCCWxvY2FsaG9zdDAJBgcqhkjOPQQBA4GMADCBiAJCAcjceelwdAsjUd7yiEXZ/bqKPEPT6gZTvGbZXXhfjKiP" +
+	"1L3J261cZ+3efpTY+itmGNhs8FfiHcUcjyUrB09N6cMNAkIBObLMbh2VwsNcyxVz4pRByqcdQd7pkcEfatJVXOt3fcxU/w8+S0Kh" +
+	"dNlrXoCktyq+jO0fH59kL7Hczj6pXDeexNktI9x3D2oVeANer3VuxRfXjQsaaw=="
+
+// otherJKS is testdata/keystore.jks from github.com/pavlo-v-chernykh/keystore-go v4.5.0 (store and key password
+// "password"): an RSA key, with no certs, so another JKS implementation wrote it rather than keytool.
+const otherJKS = "/u3+7QAAAAIAAAABAAAAAQAFYWxpYXMAAAFempXEcAAAArkwggK1MAwGCisGAQQBKgIRAQEEggKj1lb29l85edsnfe/UD//BXUtI" +
+	"w19eILiDzcQaEBNzjbr2hHQwY4GxVpF2RcY2hrqDyGJfxHBtBQ5ONmruUGkY9qlMmhOch93cBUXuz2eijjjptU7zWcmUyVfzeYJC" +
+	"pRfpS1duy+s93CkAgSg30GbsskPPae5vlcGPzZGrLLBzNTifLmT8txhfhfAdrOGMdFFHKD6LuDfuUmaN696paifRjQFOXayXZH8K" +
+	"fm88IBl+mB7m7UMgB+5JsOqym3Y5dDZ9a+Z47GlsNsjfmPHqGfYU4BuG7rvh4UjcGwE+dlnKEy1vDZ8uXnJZMXYs7uaYr4OIkqiz" +
+	"lAM4JH/OKaJvFed7sxDjmbBpD5U2+wC6cWUYyiENRR4dNCYSRLOYSs4jmYUMRMrYEzEEll/E2fezUaGC8oZ9ULDFCB5xrI7Uz273" +
+	"vjK+53WLfCyUww53vVzq8oOUl9y45sj4qIoxBOo8Tud3uWj7B7fywgu00Bk906sHfKQFDEfDyBLDreTiYei17tnxc0EXu6Q1GHMI" +
+	"BonhVEW18uXvZIbpJnuvbsXFKOo/72DKP5C9Wj2Ze7ovHAogMdgwzoVPG1W2KRshHal36FjaoOV5zgrkoxxBg72lmaqSepdhvmme" +
+	"fXa5NFPVO86qxMo0x/4J3m8jQwvHj/ygusG8zoFNstpPds9PYWa2hiMbzrfu2J68n0xmM+m35VaDovjuqo15ZkHVk9dwvTri2BoB" +
+	"NHIm6qaJTEgIy0an7rig5rBHmzbwuqP7MgSfCXTg8kXMWMvRBMXGf4fgStVX7rjSils7ZZsTdpCnO4Kln2wg28q7ZsBd0YvxNZCy" +
+	"12l1Nw9xb0ObJ2q9CSyYy2fh4aiwAS9xr62av19LnKbdf6hjeSeugdpRc7fugJLYgvxIjNNThjaeAAAAAENJa2ZgiuyIf3TKfNba" +
+	"pTiH9HRo"
+
+// TestReadJKS_fixtures reads keystores that other tools wrote, so that a mistake shared by NewJKS and ReadJKS can't
+// go unnoticed. The hashes were taken with openssl from the PEM files published alongside them.
+func TestReadJKS_fixtures(t *testing.T) {
+	sha256Hex := func(b []byte) string {
+		sum := sha256.Sum256(b)
+		return hex.EncodeToString(sum[:])
+	}
+
+	for _, tt := range []struct {
+		name      string
+		keystore  string
+		alias     string
+		keySHA256 string // of the key's public key, in PKIX form
+		certs     []string
+	}{
+		{
+			name:      "jceks",
+			keystore:  keytoolJCEKS,
+			alias:     "example-elliptic-sha1",
+			keySHA256: "03a96b40d8ea7274d9ed05871e37f57ed5b89b177fd4ce2dfbc71e7d46191c42",
+			certs:     []string{"82094bacb7ee579abc7d2bcd4a595510a68e3fbc53a05b0c2951be75408cecfb"},
+		},
+		{
+			name:      "jks",
+			keystore:  otherJKS,
+			alias:     "alias",
+			keySHA256: "a1b5903d86acbd406b3cd76f3c2754c453508a5b3c2b7f58be3599f3e4e13d9d",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := base64.StdEncoding.DecodeString(tt.keystore)
+			assert.Nil(t, err)
+			entries, err := ReadJKS(data, "password")
+			if !assert.Nil(t, err) || !assert.Len(t, entries, 1) {
+				return
+			}
+			assert.Equal(t, tt.alias, entries[0].Alias)
+
+			key, err := x509.ParsePKCS8PrivateKey(entries[0].PrivateKey)
+			if !assert.Nil(t, err) {
+				return
+			}
+			pub, err := x509.MarshalPKIXPublicKey(key.(crypto.Signer).Public())
+			assert.Nil(t, err)
+			assert.Equal(t, tt.keySHA256, sha256Hex(pub))
+
+			var certs []string
+			for _, der := range entries[0].Certs {
+				certs = append(certs, sha256Hex(der))
+			}
+			assert.Equal(t, tt.certs, certs)
+
+			_, err = ReadJKS(data, "wrong")
+			assert.NotNil(t, err)
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package keystore
+
+import (
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/pki"
+	"tls-tools/internal/random"
+)
+
+// PBES2 salts are longer than PKCS#12 ones; 16 bytes is what OpenSSL uses
+const pbes2SaltLength = 16
+
+var legacyPEMCiphers = map[string]x509.PEMCipher{
+	"aes-128-cbc":  x509.PEMCipherAES128,
+	"aes-192-cbc":  x509.PEMCipherAES192,
+	"aes-256-cbc":  x509.PEMCipherAES256,
+	"des-ede3-cbc": x509.PEMCipher3DES,
+	"des-cbc":      x509.PEMCipherDES,
+}
+
+// EncodePrivateKey returns the cert's key as PEM in the given format, encrypted if the format has a password.
+func EncodePrivateKey(kac pki.KeyAndCert, f config.KeyFormat) ([]byte, error) {
+	key := kac.GetPrivateKey()
+	if key == nil {
+		return nil, fmt.Errorf("no private key for %s", kac.GetName())
+	}
+
+	cipherName := strings.ToLower(strings.TrimSpace(f.Cipher))
+	if cipherName == "" {
+		cipherName = defaultPBES2Cipher
+	}
+	iterations := f.Iterations
+	if iterations == 0 {
+		iterations = defaultIterations
+	}
+
+	var block *pem.Block
+	switch strings.ToLower(strings.TrimSpace(f.Format)) {
+	case "", "pkcs8":
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: kac.GetKeyDER()}
+		if f.Password != nil {
+			alg, encrypted, err := encryptPBES2([]byte(*f.Password), random.Bytes(pbes2SaltLength), iterations, cipherName, block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", kac.GetName(), err)
+			}
+			der, err := asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: alg, EncryptedData: encrypted})
+			if err != nil {
+				return nil, err
+			}
+			block = &pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: der}
+		}
+	case "pkcs1":
+		k, ok := key.(*rsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: PKCS#1 needs an RSA key, not %T", kac.GetName(), key)
+		}
+		block = &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}
+	case "sec1":
+		k, ok := key.(*ecdsa.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: SEC 1 needs an ECDSA key, not %T", kac.GetName(), key)
+		}
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		block = &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}
+	case "openssh":
+		var err error
+		if f.Password != nil {
+			block, err = ssh.MarshalPrivateKeyWithPassphrase(key, kac.GetName(), []byte(*f.Password))
+		} else {
+			block, err = ssh.MarshalPrivateKey(key, kac.GetName())
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", kac.GetName(), err)
+		}
+		return pem.EncodeToMemory(block), nil
+	default:
+		return nil, fmt.Errorf("invalid key format: %s", f.Format)
+	}
+
+	if f.Password != nil && block.Type != "ENCRYPTED PRIVATE KEY" {
+		c, ok := legacyPEMCiphers[cipherName]
+		if !ok {
+			return nil, fmt.Errorf("%s: unsupported cipher: %s", kac.GetName(), f.Cipher)
+		}
+		// deprecated because it is unauthenticated, which is fine for test keys
+		encrypted, err := x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, []byte(*f.Password), c)
+		if err != nil {
+			return nil, err
+		}
+		block = encrypted
+	}
+
+	return pem.EncodeToMemory(block), nil
+}
@@ -0,0 +1,86 @@
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/crypto/ssh"
+
+	"tls-tools/internal/config"
+)
+
+func TestEncodePrivateKey(t *testing.T) {
+	store := newTestStore(t)
+	leaf := store["leaf"]
+	password := "secret"
+
+	for _, f := range []config.KeyFormat{
+		{Format: "sec1"},
+		{Format: "sec1", Password: &password, Cipher: "des-ede3-cbc"},
+		{Format: "openssh"},
+		{Format: "openssh", Password: &password},
+	} {
+		keyPEM, err := EncodePrivateKey(leaf, f)
+		assert.Nil(t, err, f.Format)
+
+		var key interface{}
+		if f.Password != nil {
+			key, err = ssh.ParseRawPrivateKeyWithPassphrase(keyPEM, []byte(password))
+		} else {
+			key, err = ssh.ParseRawPrivateKey(keyPEM)
+		}
+		assert.Nil(t, err, f.Format)
+		assert.True(t, leaf.GetPrivateKey().(*ecdsa.PrivateKey).Equal(key), f.Format)
+	}
+
+	_, err := EncodePrivateKey(leaf, config.KeyFormat{Format: "pkcs1"})
+	assert.NotNil(t, err)
+	_, err = EncodePrivateKey(leaf, config.KeyFormat{Password: &password, Cipher: "rc4"})
+	assert.NotNil(t, err)
+}
+
+func TestEncodePrivateKey_pbes2(t *testing.T) {
+	store := newTestStore(t)
+	password := "secret"
+
+	keyPEM, err := EncodePrivateKey(store["leaf"], config.KeyFormat{Password: &password, Cipher: "aes-128-cbc", Iterations: 1000})
+	assert.Nil(t, err)
+	block, _ := pem.Decode(keyPEM)
+	assert.Equal(t, "ENCRYPTED PRIVATE KEY", block.Type)
+
+	var info encryptedPrivateKeyInfo
+	_, err = asn1.Unmarshal(block.Bytes, &info)
+	assert.Nil(t, err)
+	assert.Equal(t, oidPBES2, info.Algorithm.Algorithm)
+
+	var params pbes2Params
+	_, err = asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params)
+	assert.Nil(t, err)
+	var kdf pbkdf2Params
+	_, err = asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf)
+	assert.Nil(t, err)
+	assert.Equal(t, 1000, kdf.Iterations)
+	assert.Equal(t, pbes2Ciphers["aes-128-cbc"].oid, params.EncryptionScheme.Algorithm)
+	var iv []byte
+	_, err = asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv)
+	assert.Nil(t, err)
+
+	c, err := aes.NewCipher(pbkdf2.Key([]byte(password), kdf.Salt, kdf.Iterations, 16, sha256.New))
+	assert.Nil(t, err)
+	plaintext := make([]byte, len(info.EncryptedData))
+	cipher.NewCBCDecrypter(c, iv).CryptBlocks(plaintext, info.EncryptedData)
+	plaintext = plaintext[:len(plaintext)-int(plaintext[len(plaintext)-1])]
+
+	key, err := x509.ParsePKCS8PrivateKey(plaintext)
+	assert.Nil(t, err)
+	assert.Equal(t, store["leaf"].GetKeyDER(), plaintext)
+	assert.NotNil(t, key)
+}
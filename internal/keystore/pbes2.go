@@ -0,0 +1,85 @@
+package keystore
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+
+	"tls-tools/internal/random"
+)
+
+const defaultPBES2Cipher = "aes-256-cbc"
+
+var (
+	oidPBES2          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	oidPBKDF2         = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+)
+
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+type pbkdf2Params struct {
+	Salt       []byte
+	Iterations int
+	PRF        pkix.AlgorithmIdentifier
+}
+
+type pbes2Cipher struct {
+	oid      asn1.ObjectIdentifier
+	keyLen   int
+	newBlock func(key []byte) (cipher.Block, error)
+}
+
+var pbes2Ciphers = map[string]pbes2Cipher{
+	"aes-128-cbc":  {asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}, 16, aes.NewCipher},
+	"aes-192-cbc":  {asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}, 24, aes.NewCipher},
+	"aes-256-cbc":  {asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}, 32, aes.NewCipher},
+	"des-ede3-cbc": {asn1.ObjectIdentifier{1, 2, 840, 113549, 3, 7}, 24, des.NewTripleDESCipher},
+}
+
+// encryptPBES2 encrypts with a key derived by PBKDF2 (HMAC-SHA256) from the password, as in RFC 8018.
+func encryptPBES2(password, salt []byte, iterations int, cipherName string, plaintext []byte) (pkix.AlgorithmIdentifier, []byte, error) {
+	c, ok := pbes2Ciphers[strings.ToLower(strings.TrimSpace(cipherName))]
+	if !ok {
+		return pkix.AlgorithmIdentifier{}, nil, fmt.Errorf("unsupported cipher: %s", cipherName)
+	}
+
+	block, err := c.newBlock(pbkdf2.Key(password, salt, iterations, c.keyLen, sha256.New))
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	iv := random.Bytes(block.BlockSize())
+
+	kdfParams, err := asn1.Marshal(pbkdf2Params{
+		Salt:       salt,
+		Iterations: iterations,
+		PRF:        pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	ivDER, err := asn1.Marshal(iv)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdfParams}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: c.oid, Parameters: asn1.RawValue{FullBytes: ivDER}},
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, nil, err
+	}
+
+	alg := pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}}
+	return alg, encryptCBC(block, iv, plaintext), nil
+}
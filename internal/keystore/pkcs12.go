@@ -0,0 +1,435 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/cipher"
+	"crypto/des"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"hash"
+	"strings"
+	"unicode/utf16"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/pki"
+	"tls-tools/internal/random"
+)
+
+const (
+	DefaultPassword = "changeit"
+
+	defaultIterations = 2048
+	saltLength        = 8
+)
+
+var (
+	oidData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	oidEncryptedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 6}
+
+	oidPKCS8ShroudedKeyBag = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 2}
+	oidCertBag             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 10, 1, 3}
+	oidX509Certificate     = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 22, 1}
+
+	oidFriendlyName = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 20}
+	oidLocalKeyID   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 21}
+	// Java only treats a cert without a key as trusted if it has this attribute
+	oidJavaTrustedKeyUsage = asn1.ObjectIdentifier{2, 16, 840, 1, 113894, 746875, 1, 1}
+	oidAnyExtendedKeyUsage = asn1.ObjectIdentifier{2, 5, 29, 37, 0}
+
+	oidPBEWithSHAAnd3KeyTripleDESCBC = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 3}
+	oidPBEWithSHAAnd40BitRC2CBC      = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 12, 1, 6}
+
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+)
+
+type pfxPDU struct {
+	Version  int
+	AuthSafe contentInfo
+	MacData  macData
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+type encryptedData struct {
+	Version              int
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0"`
+}
+
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+type safeBag struct {
+	ID         asn1.ObjectIdentifier
+	Value      asn1.RawValue
+	Attributes []pkcs12Attribute `asn1:"set,optional"`
+}
+
+type certBag struct {
+	ID   asn1.ObjectIdentifier
+	Data asn1.RawValue
+}
+
+type pkcs12Attribute struct {
+	ID     asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type macData struct {
+	Mac        digestInfo
+	MacSalt    []byte
+	Iterations int
+}
+
+type digestInfo struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Digest    []byte
+}
+
+type pbeParams struct {
+	Salt       []byte
+	Iterations int
+}
+
+// NewPKCS12 creates a PKCS#12 file with the named cert, its key (unless it's trust-only) and its chain. Each cert's
+// friendly name is its name in the store.
+func NewPKCS12(name string, store pki.Store, opts config.PKCS12) ([]byte, error) {
+	kac, ok := store[name]
+	if !ok {
+		return nil, fmt.Errorf("failed to find cert named %s", name)
+	}
+
+	enc, err := newPKCS12Encryption(opts)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	friendlyName := opts.FriendlyName
+	if friendlyName == "" {
+		friendlyName = name
+	}
+	trustOnly := opts.TrustOnly || kac.GetKeyDER() == nil
+
+	var certBags, keyBags []safeBag
+	localKeyID := sha1.Sum(kac.GetCertDER())
+	for n, der := friendlyName, kac.GetCertDER(); der != nil; {
+		attrs := []pkcs12Attribute{bmpStringAttribute(oidFriendlyName, n)}
+		if trustOnly {
+			attrs = append(attrs, oidAttribute(oidJavaTrustedKeyUsage, oidAnyExtendedKeyUsage))
+		} else if len(certBags) == 0 {
+			attrs = append(attrs, octetStringAttribute(oidLocalKeyID, localKeyID[:]))
+		}
+
+		bag, err := newCertBag(der, attrs)
+		if err != nil {
+			return nil, err
+		}
+		certBags = append(certBags, bag)
+
+		parent := kac.GetParentName()
+		if parent == "" {
+			break
+		}
+		kac = store[parent]
+		n, der = parent, kac.GetCertDER()
+	}
+
+	if !trustOnly {
+		alg, encrypted, err := enc.encrypt(store[name].GetKeyDER(), true)
+		if err != nil {
+			return nil, err
+		}
+		value, err := asn1.Marshal(encryptedPrivateKeyInfo{Algorithm: alg, EncryptedData: encrypted})
+		if err != nil {
+			return nil, err
+		}
+		keyBags = append(keyBags, safeBag{
+			ID:    oidPKCS8ShroudedKeyBag,
+			Value: explicit(0, value),
+			Attributes: []pkcs12Attribute{
+				bmpStringAttribute(oidFriendlyName, friendlyName),
+				octetStringAttribute(oidLocalKeyID, localKeyID[:]),
+			},
+		})
+	}
+
+	return encodePKCS12(certBags, keyBags, enc)
+}
+
+// NewPKCS12TrustStore creates a PKCS#12 file with each of the named certs, marked as trusted, and no keys. Each cert's
+// friendly name is its name in the store; the FriendlyName and TrustOnly options don't apply.
+func NewPKCS12TrustStore(names []string, store pki.Store, opts config.PKCS12) ([]byte, error) {
+	enc, err := newPKCS12Encryption(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var certBags []safeBag
+	for _, name := range names {
+		kac, ok := store[name]
+		if !ok {
+			return nil, fmt.Errorf("failed to find cert named %s", name)
+		}
+		bag, err := newCertBag(kac.GetCertDER(), []pkcs12Attribute{
+			bmpStringAttribute(oidFriendlyName, name),
+			oidAttribute(oidJavaTrustedKeyUsage, oidAnyExtendedKeyUsage),
+		})
+		if err != nil {
+			return nil, err
+		}
+		certBags = append(certBags, bag)
+	}
+
+	return encodePKCS12(certBags, nil, enc)
+}
+
+func encodePKCS12(certBags, keyBags []safeBag, enc pkcs12Encryption) ([]byte, error) {
+	// Certs go in an encrypted container; the key is already encrypted, so its container isn't
+	certsDER, err := asn1.Marshal(certBags)
+	if err != nil {
+		return nil, err
+	}
+	alg, encrypted, err := enc.encrypt(certsDER, false)
+	if err != nil {
+		return nil, err
+	}
+	ed, err := asn1.Marshal(encryptedData{EncryptedContentInfo: encryptedContentInfo{
+		ContentType:                oidData,
+		ContentEncryptionAlgorithm: alg,
+		EncryptedContent:           encrypted,
+	}})
+	if err != nil {
+		return nil, err
+	}
+	authSafe := []contentInfo{{ContentType: oidEncryptedData, Content: explicit(0, ed)}}
+
+	if len(keyBags) > 0 {
+		keysDER, err := asn1.Marshal(keyBags)
+		if err != nil {
+			return nil, err
+		}
+		ci, err := dataContentInfo(keysDER)
+		if err != nil {
+			return nil, err
+		}
+		authSafe = append(authSafe, ci)
+	}
+
+	authSafeDER, err := asn1.Marshal(authSafe)
+	if err != nil {
+		return nil, err
+	}
+	pfx := pfxPDU{Version: 3}
+	pfx.AuthSafe, err = dataContentInfo(authSafeDER)
+	if err != nil {
+		return nil, err
+	}
+	pfx.MacData = enc.mac(authSafeDER)
+
+	return asn1.Marshal(pfx)
+}
+
+type pkcs12Encryption struct {
+	password   string
+	legacy     bool
+	iterations int
+}
+
+func newPKCS12Encryption(opts config.PKCS12) (pkcs12Encryption, error) {
+	enc := pkcs12Encryption{
+		password:   DefaultPassword,
+		iterations: defaultIterations,
+	}
+	if opts.Password != nil {
+		enc.password = *opts.Password
+	}
+	if opts.Iterations != 0 {
+		enc.iterations = opts.Iterations
+	}
+
+	switch strings.ToLower(strings.TrimSpace(opts.Encryption)) {
+	case "", "modern":
+	case "legacy":
+		enc.legacy = true
+	default:
+		return enc, fmt.Errorf("invalid PKCS#12 encryption: %s", opts.Encryption)
+	}
+
+	return enc, nil
+}
+
+// encrypt uses PBES2 with AES-256, or else the legacy PKCS#12 PBE schemes: 3DES for keys and 40-bit RC2 for certs.
+func (e pkcs12Encryption) encrypt(plaintext []byte, isKey bool) (pkix.AlgorithmIdentifier, []byte, error) {
+	salt := random.Bytes(saltLength)
+
+	if e.legacy {
+		params, err := asn1.Marshal(pbeParams{Salt: salt, Iterations: e.iterations})
+		if err != nil {
+			return pkix.AlgorithmIdentifier{}, nil, err
+		}
+
+		password := bmpString(e.password)
+		var block cipher.Block
+		alg := pkix.AlgorithmIdentifier{Parameters: asn1.RawValue{FullBytes: params}}
+		if isKey {
+			alg.Algorithm = oidPBEWithSHAAnd3KeyTripleDESCBC
+			block, err = des.NewTripleDESCipher(pkcs12KDF(sha1.New, 64, password, salt, e.iterations, 1, 24))
+			if err != nil {
+				return alg, nil, err
+			}
+		} else {
+			alg.Algorithm = oidPBEWithSHAAnd40BitRC2CBC
+			block = newRC2Cipher(pkcs12KDF(sha1.New, 64, password, salt, e.iterations, 1, 5), 40)
+		}
+		iv := pkcs12KDF(sha1.New, 64, password, salt, e.iterations, 2, block.BlockSize())
+
+		return alg, encryptCBC(block, iv, plaintext), nil
+	}
+
+	return encryptPBES2([]byte(e.password), salt, e.iterations, defaultPBES2Cipher, plaintext)
+}
+
+func (e pkcs12Encryption) mac(content []byte) macData {
+	h, oid := sha256.New, oidSHA256
+	if e.legacy {
+		h, oid = sha1.New, oidSHA1
+	}
+
+	salt := random.Bytes(saltLength)
+	key := pkcs12KDF(h, 64, bmpString(e.password), salt, e.iterations, 3, h().Size())
+	m := hmac.New(h, key)
+	m.Write(content)
+
+	return macData{
+		Mac: digestInfo{
+			Algorithm: pkix.AlgorithmIdentifier{Algorithm: oid, Parameters: asn1.NullRawValue},
+			Digest:    m.Sum(nil),
+		},
+		MacSalt:    salt,
+		Iterations: e.iterations,
+	}
+}
+
+// pkcs12KDF derives keys, IVs and MAC keys from a password as described in RFC 7292, appendix B.2. The hash has a
+// block size of v bytes.
+func pkcs12KDF(h func() hash.Hash, v int, password, salt []byte, iterations int, id byte, n int) []byte {
+	fill := func(b []byte, length int) []byte {
+		out := make([]byte, length)
+		for i := range out {
+			out[i] = b[i%len(b)]
+		}
+		return out
+	}
+	roundUp := func(length int) int {
+		return v * ((length + v - 1) / v)
+	}
+
+	d := bytes.Repeat([]byte{id}, v)
+	var i []byte
+	if len(salt) > 0 {
+		i = append(i, fill(salt, roundUp(len(salt)))...)
+	}
+	if len(password) > 0 {
+		i = append(i, fill(password, roundUp(len(password)))...)
+	}
+
+	var out []byte
+	for {
+		hh := h()
+		hh.Write(d)
+		hh.Write(i)
+		a := hh.Sum(nil)
+		for r := 1; r < iterations; r++ {
+			hh = h()
+			hh.Write(a)
+			a = hh.Sum(nil)
+		}
+
+		out = append(out, a...)
+		if len(out) >= n {
+			return out[:n]
+		}
+
+		// Treat each v-byte block of I as a big-endian integer, and add B + 1 to it
+		b := fill(a, v)
+		for j := 0; j < len(i); j += v {
+			carry := 1
+			for k := v - 1; k >= 0; k-- {
+				sum := int(i[j+k]) + int(b[k]) + carry
+				i[j+k] = byte(sum)
+				carry = sum >> 8
+			}
+		}
+	}
+}
+
+func encryptCBC(block cipher.Block, iv, plaintext []byte) []byte {
+	pad := block.BlockSize() - len(plaintext)%block.BlockSize()
+	padded := append(append([]byte{}, plaintext...), bytes.Repeat([]byte{byte(pad)}, pad)...)
+	ciphertext := make([]byte, len(padded))
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(ciphertext, padded)
+	return ciphertext
+}
+
+// bmpString encodes a password as a null-terminated BMPString, which is what the PKCS#12 KDF expects.
+func bmpString(s string) []byte {
+	var b []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(c>>8), byte(c))
+	}
+	return append(b, 0, 0)
+}
+
+func newCertBag(der []byte, attrs []pkcs12Attribute) (safeBag, error) {
+	octets, err := asn1.Marshal(der)
+	if err != nil {
+		return safeBag{}, err
+	}
+	value, err := asn1.Marshal(certBag{ID: oidX509Certificate, Data: explicit(0, octets)})
+	if err != nil {
+		return safeBag{}, err
+	}
+	return safeBag{ID: oidCertBag, Value: explicit(0, value), Attributes: attrs}, nil
+}
+
+func dataContentInfo(content []byte) (contentInfo, error) {
+	octets, err := asn1.Marshal(content)
+	if err != nil {
+		return contentInfo{}, err
+	}
+	return contentInfo{ContentType: oidData, Content: explicit(0, octets)}, nil
+}
+
+func bmpStringAttribute(oid asn1.ObjectIdentifier, s string) pkcs12Attribute {
+	b := bmpString(s)
+	return pkcs12Attribute{ID: oid, Values: []asn1.RawValue{{Tag: asn1.TagBMPString, Bytes: b[:len(b)-2]}}}
+}
+
+func octetStringAttribute(oid asn1.ObjectIdentifier, b []byte) pkcs12Attribute {
+	return pkcs12Attribute{ID: oid, Values: []asn1.RawValue{{Tag: asn1.TagOctetString, Bytes: b}}}
+}
+
+func oidAttribute(oid, value asn1.ObjectIdentifier) pkcs12Attribute {
+	der, _ := asn1.Marshal(value)
+	return pkcs12Attribute{ID: oid, Values: []asn1.RawValue{{FullBytes: der}}}
+}
+
+// explicit wraps DER in an explicit context-specific tag. (asn1.Marshal ignores the explicit option on RawValues.)
+func explicit(tag int, der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, IsCompound: true, Bytes: der}
+}
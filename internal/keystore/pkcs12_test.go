@@ -0,0 +1,161 @@
+package keystore
+
+import (
+	"crypto/cipher"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/pkcs12"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/pki"
+)
+
+func TestRC2(t *testing.T) {
+	// RFC 2268, section 5
+	c := newRC2Cipher(make([]byte, 8), 63)
+	dst := make([]byte, 8)
+	c.Encrypt(dst, make([]byte, 8))
+	assert.Equal(t, "ebb773f993278eff", hex.EncodeToString(dst))
+
+	c.Decrypt(dst, dst)
+	assert.Equal(t, make([]byte, 8), dst)
+}
+
+func TestNewPKCS12_legacy(t *testing.T) {
+	store := newTestStore(t)
+	password := "secret"
+
+	p12, err := NewPKCS12("leaf", store, config.PKCS12{Password: &password, Encryption: "legacy"})
+	assert.Nil(t, err)
+
+	blocks, err := pkcs12.ToPEM(p12, password)
+	assert.Nil(t, err)
+	assert.Len(t, blocks, 3)
+
+	var certs []string
+	for _, b := range blocks {
+		if b.Type == "PRIVATE KEY" {
+			// x/crypto turns ECDSA keys into SEC 1, in spite of the type
+			key, err := x509.ParseECPrivateKey(b.Bytes)
+			assert.Nil(t, err)
+			assert.True(t, key.Equal(store["leaf"].GetPrivateKey()))
+			assert.Equal(t, "leaf", b.Headers["friendlyName"])
+		} else {
+			certs = append(certs, b.Headers["friendlyName"])
+		}
+	}
+	assert.Equal(t, []string{"leaf", "ca"}, certs)
+
+	_, err = pkcs12.ToPEM(p12, "wrong")
+	assert.NotNil(t, err)
+}
+
+func TestNewPKCS12_trustOnly(t *testing.T) {
+	store := newTestStore(t)
+
+	p12, err := NewPKCS12("ca", store, config.PKCS12{Encryption: "legacy", TrustOnly: true, FriendlyName: "my root"})
+	assert.Nil(t, err)
+
+	bags := legacyCertBags(t, p12)
+	assert.Len(t, bags, 1)
+	assert.Len(t, bags[0].Attributes, 2)
+}
+
+func TestNewPKCS12TrustStore(t *testing.T) {
+	store, err := pki.NewStoreFromConfig(map[string]config.Cert{
+		"ca":    {KeyType: "P-256", Purpose: "root-ca"},
+		"other": {KeyType: "P-256", Purpose: "root-ca"},
+		"leaf":  {KeyType: "P-256", Parent: "ca"},
+	})
+	assert.Nil(t, err)
+
+	p12, err := NewPKCS12TrustStore([]string{"ca", "other"}, store, config.PKCS12{Encryption: "legacy"})
+	assert.Nil(t, err)
+
+	bags := legacyCertBags(t, p12)
+	if assert.Len(t, bags, 2) {
+		for i, name := range []string{"ca", "other"} {
+			var cb certBag
+			_, err = asn1.Unmarshal(bags[i].Value.Bytes, &cb)
+			assert.Nil(t, err)
+			var der []byte
+			_, err = asn1.Unmarshal(cb.Data.Bytes, &der)
+			assert.Nil(t, err)
+			assert.Equal(t, store[name].GetCertDER(), der)
+			// DER sorts the attributes
+			attrs := make(map[string][]byte)
+			for _, a := range bags[i].Attributes {
+				attrs[a.ID.String()] = a.Values[0].FullBytes
+			}
+			anyEKU, err := asn1.Marshal(oidAnyExtendedKeyUsage)
+			assert.Nil(t, err)
+			// bmpString adds the terminator that passwords have and friendly names don't
+			friendlyName := append([]byte{asn1.TagBMPString, byte(2 * len(name))}, bmpString(name)[:2*len(name)]...)
+			assert.Equal(t, map[string][]byte{
+				oidFriendlyName.String():        friendlyName,
+				oidJavaTrustedKeyUsage.String(): anyEKU,
+			}, attrs)
+		}
+	}
+
+	_, err = NewPKCS12TrustStore([]string{"missing"}, store, config.PKCS12{})
+	assert.NotNil(t, err)
+}
+
+// legacyCertBags decrypts the certs in a PKCS#12 file with no key, made with the legacy algorithms. The x/crypto decoder
+// insists on a key, so it's done by hand.
+func legacyCertBags(t *testing.T, p12 []byte) []safeBag {
+	var pfx pfxPDU
+	_, err := asn1.Unmarshal(p12, &pfx)
+	assert.Nil(t, err)
+	var authSafeDER []byte
+	_, err = asn1.Unmarshal(pfx.AuthSafe.Content.Bytes, &authSafeDER)
+	assert.Nil(t, err)
+	var authSafe []contentInfo
+	_, err = asn1.Unmarshal(authSafeDER, &authSafe)
+	assert.Nil(t, err)
+	assert.Len(t, authSafe, 1)
+
+	var ed encryptedData
+	_, err = asn1.Unmarshal(authSafe[0].Content.Bytes, &ed)
+	assert.Nil(t, err)
+	var params pbeParams
+	_, err = asn1.Unmarshal(ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Parameters.FullBytes, &params)
+	assert.Nil(t, err)
+
+	password := bmpString(DefaultPassword)
+	key := pkcs12KDF(sha1.New, 64, password, params.Salt, params.Iterations, 1, 5)
+	iv := pkcs12KDF(sha1.New, 64, password, params.Salt, params.Iterations, 2, 8)
+	plaintext := make([]byte, len(ed.EncryptedContentInfo.EncryptedContent))
+	cipher.NewCBCDecrypter(newRC2Cipher(key, 40), iv).CryptBlocks(plaintext, ed.EncryptedContentInfo.EncryptedContent)
+
+	var bags []safeBag
+	_, err = asn1.Unmarshal(plaintext, &bags)
+	assert.Nil(t, err)
+	return bags
+}
+
+func TestNewPKCS12_modern(t *testing.T) {
+	store := newTestStore(t)
+
+	p12, err := NewPKCS12("leaf", store, config.PKCS12{})
+	assert.Nil(t, err)
+
+	// The x/crypto decoder only supports the legacy algorithms, but it can at least check the structure
+	_, err = pkcs12.ToPEM(p12, DefaultPassword)
+	assert.ErrorContains(t, err, "unknown digest algorithm")
+}
+
+func newTestStore(t *testing.T) pki.Store {
+	store, err := pki.NewStoreFromConfig(map[string]config.Cert{
+		"ca":   {KeyType: "P-256", Purpose: "root-ca"},
+		"leaf": {KeyType: "P-256", Parent: "ca"},
+	})
+	assert.Nil(t, err)
+	return store
+}
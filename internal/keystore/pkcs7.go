@@ -0,0 +1,35 @@
+package keystore
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+)
+
+var oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+// A degenerate SignedData (RFC 5652, section 5.2) has certs but no content and no signers.
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      struct{ ContentType asn1.ObjectIdentifier }
+	Certificates     asn1.RawValue
+	SignerInfos      []asn1.RawValue `asn1:"set"`
+}
+
+// NewPKCS7 creates a certs-only PKCS#7 bundle, as in a .p7b or .p7c file, with the given DER certs in order.
+func NewPKCS7(ders [][]byte) ([]byte, error) {
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: bytes.Join(ders, nil)},
+		SignerInfos:      []asn1.RawValue{},
+	}
+	sd.ContentInfo.ContentType = oidData
+
+	der, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(contentInfo{ContentType: oidSignedData, Content: explicit(0, der)})
+}
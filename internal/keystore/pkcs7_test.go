@@ -0,0 +1,35 @@
+package keystore
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewPKCS7(t *testing.T) {
+	store := newTestStore(t)
+	chain := store["leaf"].GetCertChainDER()
+
+	p7, err := NewPKCS7(chain)
+	assert.Nil(t, err)
+
+	var ci contentInfo
+	_, err = asn1.Unmarshal(p7, &ci)
+	assert.Nil(t, err)
+	assert.Equal(t, oidSignedData, ci.ContentType)
+
+	var sd signedData
+	_, err = asn1.Unmarshal(ci.Content.Bytes, &sd)
+	assert.Nil(t, err)
+	assert.Equal(t, oidData, sd.ContentInfo.ContentType)
+	assert.Empty(t, sd.SignerInfos)
+
+	certs, err := x509.ParseCertificates(sd.Certificates.Bytes)
+	assert.Nil(t, err)
+	if assert.Len(t, certs, 2) {
+		assert.Equal(t, chain[0], certs[0].Raw)
+		assert.Equal(t, chain[1], certs[1].Raw)
+	}
+}
@@ -0,0 +1,101 @@
+package pki
+
+import (
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"sort"
+
+	"tls-tools/internal/config"
+)
+
+var oidExtensionAuthorityKeyId = asn1.ObjectIdentifier{2, 5, 29, 35}
+
+// NewCRL creates a CRL (in DER form) signed by the named CA, listing every cert it issued that is configured as
+// revoked.
+func (s *Store) NewCRL(caName string, opts config.CRLOptions) ([]byte, error) {
+	ca, ok := (*s)[caName]
+	if !ok {
+		return nil, fmt.Errorf("failed to find cert named %s", caName)
+	}
+
+	signer := ca.privateKey
+	if opts.Signer != "" {
+		other, ok := (*s)[opts.Signer]
+		if !ok {
+			return nil, fmt.Errorf("failed to find cert named %s", opts.Signer)
+		}
+		signer = other.privateKey
+	}
+	if signer == nil {
+		return nil, fmt.Errorf("%s: cannot sign a CRL without a private key", caName)
+	}
+
+	tmpl, err := opts.ToTemplate()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", caName, err)
+	}
+
+	for _, c := range *s {
+		if c.parentCert != caName || c.cfg.Revoked == nil {
+			continue
+		}
+		rc, err := c.cfg.Revoked.ToRevokedCertificate(c.certificate.SerialNumber, c.certificate.NotBefore)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", c.name, err)
+		}
+		tmpl.RevokedCertificates = append(tmpl.RevokedCertificates, rc)
+	}
+	sort.Slice(tmpl.RevokedCertificates, func(i, j int) bool {
+		return tmpl.RevokedCertificates[i].SerialNumber.Cmp(tmpl.RevokedCertificates[j].SerialNumber) < 0
+	})
+
+	der, err := x509.CreateRevocationList(rand.Reader, tmpl, ca.certificate, signer)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", caName, err)
+	}
+
+	if opts.OmitAKI {
+		crl, err := x509.ParseRevocationList(der)
+		if err != nil {
+			return nil, err
+		}
+		der, err = removeCRLExtension(der, oidExtensionAuthorityKeyId, crl.SignatureAlgorithm, signer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.BadSignature {
+		der, err = corruptSignature(der)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return der, nil
+}
+
+func (s *Store) createCRLs() error {
+	for name, kac := range *s {
+		if !kac.certificate.IsCA || kac.privateKey == nil {
+			continue
+		}
+
+		opts := config.CRLOptions{}
+		if kac.cfg.CRL != nil {
+			opts = *kac.cfg.CRL
+		} else if kac.certificate.KeyUsage&x509.KeyUsageCRLSign == 0 {
+			continue
+		}
+
+		var err error
+		kac.crlDER, err = s.NewCRL(name, opts)
+		if err != nil {
+			return err
+		}
+		(*s)[name] = kac
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package pki
+
+import (
+	"crypto/x509"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"tls-tools/internal/config"
+)
+
+func TestNewStoreFromConfig_crls(t *testing.T) {
+	number := int64(42)
+	store, err := NewStoreFromConfig(map[string]config.Cert{
+		"ca":      {KeyType: "P-256", Purpose: "root-ca", CRL: &config.CRLOptions{Number: &number, ThisUpdate: "-48h", NextUpdate: "-1h"}},
+		"revoked": {KeyType: "P-256", Parent: "ca", Revoked: &config.Revocation{Reason: "keyCompromise"}},
+		"good":    {KeyType: "P-256", Parent: "ca"},
+		"leaf":    {KeyType: "P-256"},
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, store["good"].GetCRLDER())
+	assert.Nil(t, store["leaf"].GetCRLDER())
+
+	crl, err := x509.ParseRevocationList(store["ca"].GetCRLDER())
+	assert.Nil(t, err)
+	assert.Nil(t, crl.CheckSignatureFrom(store["ca"].GetCertificate()))
+	assert.Equal(t, int64(42), crl.Number.Int64())
+	assert.True(t, crl.NextUpdate.Before(time.Now()))
+	assert.Len(t, crl.RevokedCertificates, 1)
+	assert.Equal(t, store["revoked"].GetCertificate().SerialNumber, crl.RevokedCertificates[0].SerialNumber)
+}
+
+func TestStore_NewCRL_broken(t *testing.T) {
+	store, err := NewStoreFromConfig(map[string]config.Cert{
+		"ca":    {KeyType: "P-256", Purpose: "root-ca"},
+		"other": {KeyType: "P-256", Purpose: "root-ca"},
+	})
+	assert.Nil(t, err)
+
+	der, err := store.NewCRL("ca", config.CRLOptions{OmitAKI: true})
+	assert.Nil(t, err)
+	crl, err := x509.ParseRevocationList(der)
+	assert.Nil(t, err)
+	assert.Nil(t, crl.CheckSignatureFrom(store["ca"].GetCertificate()))
+	assert.Empty(t, crl.AuthorityKeyId)
+
+	der, err = store.NewCRL("ca", config.CRLOptions{Signer: "other"})
+	assert.Nil(t, err)
+	crl, err = x509.ParseRevocationList(der)
+	assert.Nil(t, err)
+	assert.NotNil(t, crl.CheckSignatureFrom(store["ca"].GetCertificate()))
+
+	der, err = store.NewCRL("ca", config.CRLOptions{BadSignature: true})
+	assert.Nil(t, err)
+	crl, err = x509.ParseRevocationList(der)
+	assert.Nil(t, err)
+	assert.NotNil(t, crl.CheckSignatureFrom(store["ca"].GetCertificate()))
+}
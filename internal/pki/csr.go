@@ -0,0 +1,172 @@
+package pki
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"tls-tools/internal/config"
+)
+
+// ReadCSR loads a PKCS#10 request in PEM or DER form and checks its signature.
+func ReadCSR(filename string) (*x509.CertificateRequest, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if block, _ := pem.Decode(b); block != nil {
+		b = block.Bytes
+	}
+
+	csr, err := x509.ParseCertificateRequest(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	err = csr.CheckSignature()
+	if err != nil {
+		return nil, fmt.Errorf("%s: invalid signature: %w", filename, err)
+	}
+
+	return csr, nil
+}
+
+func applyCSR(tmpl *x509.Certificate, crt config.Cert, csr *x509.CertificateRequest) {
+	if len(crt.DNSNames) == 0 && len(crt.IPAddresses) == 0 && len(crt.EmailAddresses) == 0 && len(crt.URIs) == 0 {
+		tmpl.DNSNames = csr.DNSNames
+		tmpl.IPAddresses = csr.IPAddresses
+		tmpl.EmailAddresses = csr.EmailAddresses
+		tmpl.URIs = csr.URIs
+	}
+
+	if crt.Subject == nil {
+		if len(csr.Subject.Names) > 0 {
+			tmpl.RawSubject = csr.RawSubject
+		} else if len(tmpl.DNSNames) > 0 {
+			tmpl.Subject = pkix.Name{CommonName: tmpl.DNSNames[0]}
+		} else if len(tmpl.EmailAddresses) > 0 {
+			tmpl.Subject = pkix.Name{CommonName: tmpl.EmailAddresses[0]}
+		}
+	}
+}
+
+var oidChallengePassword = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}
+
+// Extensions copied from the cert into the request; the rest are the issuer's business
+var requestedExtensions = []asn1.ObjectIdentifier{
+	{2, 5, 29, 15}, // key usage
+	{2, 5, 29, 17}, // subject alternative name
+	{2, 5, 29, 19}, // basic constraints
+	{2, 5, 29, 37}, // extended key usage
+}
+
+// NewCSR creates a PKCS#10 request (in DER form) for the key, subject and extensions of an entry.
+func NewCSR(k KeyAndCert, opts config.Request) ([]byte, error) {
+	if k.privateKey == nil {
+		return nil, fmt.Errorf("%s: cannot create a CSR without a private key", k.name)
+	}
+
+	tmpl := x509.CertificateRequest{}
+	if !opts.EmptySubject {
+		tmpl.RawSubject = k.certificate.RawSubject
+	}
+	for _, ext := range k.certificate.Extensions {
+		for _, oid := range requestedExtensions {
+			if ext.Id.Equal(oid) {
+				tmpl.ExtraExtensions = append(tmpl.ExtraExtensions, ext)
+			}
+		}
+	}
+
+	der, err := x509.CreateCertificateRequest(rand.Reader, &tmpl, k.privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.ChallengePassword != nil {
+		der, err = addChallengePassword(der, *opts.ChallengePassword, k.privateKey)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.BadSignature {
+		der, err = corruptSignature(der)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return der, nil
+}
+
+// x509.CreateCertificateRequest can only encode extension requests, so the challenge password attribute has to be
+// added by hand.
+func addChallengePassword(csrDER []byte, password string, priv crypto.Signer) ([]byte, error) {
+	csr, err := x509.ParseCertificateRequest(csrDER)
+	if err != nil {
+		return nil, err
+	}
+
+	obj, err := parseSignedObject(csrDER)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := obj.fields()
+	if err != nil {
+		return nil, err
+	}
+
+	// CertificationRequestInfo is version, subject, subjectPKInfo and then [0] attributes
+	if len(fields) != 4 {
+		return nil, errors.New("malformed certificate request")
+	}
+
+	pw, err := asn1.MarshalWithParams(password, "utf8")
+	if err != nil {
+		return nil, err
+	}
+	attr, err := asn1.Marshal(struct {
+		Type   asn1.ObjectIdentifier
+		Values asn1.RawValue
+	}{oidChallengePassword, asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: pw}})
+	if err != nil {
+		return nil, err
+	}
+
+	attrs, err := splitSequence(fields[3])
+	if err != nil {
+		return nil, err
+	}
+	attrs = append(attrs, asn1.RawValue{FullBytes: attr})
+	sort.Slice(attrs, func(i, j int) bool {
+		return bytes.Compare(attrs[i].FullBytes, attrs[j].FullBytes) < 0
+	})
+
+	var content []byte
+	for _, a := range attrs {
+		content = append(content, a.FullBytes...)
+	}
+	fields[3].FullBytes, err = asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true,
+		Bytes: content})
+	if err != nil {
+		return nil, err
+	}
+
+	err = obj.setFields(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return obj.sign(csr.SignatureAlgorithm, priv)
+}
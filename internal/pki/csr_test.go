@@ -0,0 +1,72 @@
+package pki
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"tls-tools/internal/config"
+)
+
+func TestStore_SignCSR(t *testing.T) {
+	store, err := NewStoreFromConfig(map[string]config.Cert{
+		"ca": {KeyType: "P-256", Purpose: "root-ca"},
+	})
+	assert.Nil(t, err)
+
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	der, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: "csr.example.com"},
+		DNSNames: []string{"csr.example.com"},
+	}, priv)
+	assert.Nil(t, err)
+	csr, err := x509.ParseCertificateRequest(der)
+	assert.Nil(t, err)
+
+	kac, err := store.SignCSR("csr", csr, config.Cert{Purpose: "client"}, "ca")
+	assert.Nil(t, err)
+	crt := kac.GetCertificate()
+	assert.Equal(t, "csr.example.com", crt.Subject.CommonName)
+	assert.Equal(t, []string{"csr.example.com"}, crt.DNSNames)
+	assert.Equal(t, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}, crt.ExtKeyUsage)
+	assert.True(t, priv.PublicKey.Equal(crt.PublicKey))
+	assert.Nil(t, crt.CheckSignatureFrom(store["ca"].GetCertificate()))
+	assert.Nil(t, kac.GetKeyPEM())
+
+	kac, err = store.SignCSR("csr", csr, config.Cert{DNSNames: []string{"other.example.com"}}, "ca")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"other.example.com"}, kac.GetCertificate().DNSNames)
+
+	_, err = store.SignCSR("csr", csr, config.Cert{}, "")
+	assert.NotNil(t, err)
+}
+
+func TestNewCSR(t *testing.T) {
+	store, err := NewStoreFromConfig(map[string]config.Cert{
+		"leaf": {KeyType: "P-256", DNSNames: []string{"leaf.example.com"}},
+	})
+	assert.Nil(t, err)
+
+	password := "s3cret"
+	der, err := NewCSR(store["leaf"], config.Request{ChallengePassword: &password})
+	assert.Nil(t, err)
+	csr, err := x509.ParseCertificateRequest(der)
+	assert.Nil(t, err)
+	assert.Nil(t, csr.CheckSignature())
+	assert.Equal(t, "leaf.example.com", csr.Subject.CommonName)
+	assert.Equal(t, []string{"leaf.example.com"}, csr.DNSNames)
+	assert.Contains(t, string(csr.RawTBSCertificateRequest), password)
+
+	der, err = NewCSR(store["leaf"], config.Request{BadSignature: true, EmptySubject: true})
+	assert.Nil(t, err)
+	csr, err = x509.ParseCertificateRequest(der)
+	assert.Nil(t, err)
+	assert.NotNil(t, csr.CheckSignature())
+	assert.Empty(t, csr.Subject.Names)
+}
@@ -0,0 +1,230 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+// signedObject is the common outer structure of certificates, CRLs and CSRs.
+type signedObject struct {
+	TBS                asn1.RawValue
+	SignatureAlgorithm asn1.RawValue
+	Signature          asn1.BitString
+}
+
+func parseSignedObject(der []byte) (signedObject, error) {
+	var obj signedObject
+	rest, err := asn1.Unmarshal(der, &obj)
+	if err != nil {
+		return obj, err
+	}
+	if len(rest) > 0 {
+		return obj, errors.New("trailing data after signed object")
+	}
+	return obj, nil
+}
+
+func (o *signedObject) fields() ([]asn1.RawValue, error) {
+	return splitSequence(o.TBS)
+}
+
+func (o *signedObject) setFields(fields []asn1.RawValue) error {
+	var err error
+	o.TBS, err = joinSequence(fields)
+	return err
+}
+
+func (o *signedObject) sign(alg x509.SignatureAlgorithm, priv crypto.Signer) ([]byte, error) {
+	opts, err := signerOpts(alg)
+	if err != nil {
+		return nil, err
+	}
+
+	digest := o.TBS.FullBytes
+	if opts.HashFunc() != 0 {
+		h := opts.HashFunc().New()
+		h.Write(o.TBS.FullBytes)
+		digest = h.Sum(nil)
+	}
+
+	sig, err := priv.Sign(rand.Reader, digest, opts)
+	if err != nil {
+		return nil, err
+	}
+	o.Signature = asn1.BitString{Bytes: sig, BitLength: 8 * len(sig)}
+
+	return asn1.Marshal(*o)
+}
+
+// corruptSignature flips a bit in the signature of a certificate, CRL or CSR.
+func corruptSignature(der []byte) ([]byte, error) {
+	obj, err := parseSignedObject(der)
+	if err != nil {
+		return nil, err
+	}
+	if len(obj.Signature.Bytes) == 0 {
+		return nil, errors.New("missing signature")
+	}
+
+	obj.Signature.Bytes = append([]byte{}, obj.Signature.Bytes...)
+	obj.Signature.Bytes[len(obj.Signature.Bytes)/2] ^= 0x01
+
+	return asn1.Marshal(obj)
+}
+
+func splitSequence(seq asn1.RawValue) ([]asn1.RawValue, error) {
+	var fields []asn1.RawValue
+	rest := seq.Bytes
+	for len(rest) > 0 {
+		var f asn1.RawValue
+		var err error
+		rest, err = asn1.Unmarshal(rest, &f)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+func joinSequence(fields []asn1.RawValue) (asn1.RawValue, error) {
+	var content []byte
+	for _, f := range fields {
+		content = append(content, f.FullBytes...)
+	}
+	der, err := asn1.Marshal(asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSequence, IsCompound: true, Bytes: content})
+	if err != nil {
+		return asn1.RawValue{}, err
+	}
+	var seq asn1.RawValue
+	_, err = asn1.Unmarshal(der, &seq)
+	return seq, err
+}
+
+// replaceSerialNumber swaps the serial number in a certificate and signs it again. x509.CreateCertificate refuses to
+// use negative serial numbers, so this is the only way to get them.
+func replaceSerialNumber(certDER []byte, serial *big.Int, alg x509.SignatureAlgorithm, priv crypto.Signer) ([]byte, error) {
+	obj, err := parseSignedObject(certDER)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := obj.fields()
+	if err != nil {
+		return nil, err
+	}
+
+	// TBSCertificate starts with an optional, explicitly tagged version and then the serial number
+	i := 0
+	if len(fields) > 0 && fields[0].Class == asn1.ClassContextSpecific && fields[0].Tag == 0 {
+		i = 1
+	}
+	if len(fields) <= i {
+		return nil, errors.New("malformed certificate")
+	}
+
+	fields[i].FullBytes, err = asn1.Marshal(serial)
+	if err != nil {
+		return nil, err
+	}
+	err = obj.setFields(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return obj.sign(alg, priv)
+}
+
+// removeCRLExtension deletes an extension from a CRL and signs it again.
+func removeCRLExtension(crlDER []byte, oid asn1.ObjectIdentifier, alg x509.SignatureAlgorithm, priv crypto.Signer) ([]byte, error) {
+	obj, err := parseSignedObject(crlDER)
+	if err != nil {
+		return nil, err
+	}
+
+	fields, err := obj.fields()
+	if err != nil {
+		return nil, err
+	}
+
+	// crlExtensions is the last field of TBSCertList: [0] EXPLICIT SEQUENCE OF Extension
+	last := len(fields) - 1
+	if last < 0 || fields[last].Class != asn1.ClassContextSpecific || fields[last].Tag != 0 {
+		return crlDER, nil
+	}
+
+	var exts asn1.RawValue
+	_, err = asn1.Unmarshal(fields[last].Bytes, &exts)
+	if err != nil {
+		return nil, err
+	}
+	extFields, err := splitSequence(exts)
+	if err != nil {
+		return nil, err
+	}
+
+	var kept []asn1.RawValue
+	for _, f := range extFields {
+		var ext pkix.Extension
+		_, err = asn1.Unmarshal(f.FullBytes, &ext)
+		if err != nil {
+			return nil, err
+		}
+		if !ext.Id.Equal(oid) {
+			kept = append(kept, f)
+		}
+	}
+
+	if len(kept) == 0 {
+		fields = fields[:last]
+	} else {
+		exts, err = joinSequence(kept)
+		if err != nil {
+			return nil, err
+		}
+		fields[last].FullBytes, err = asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0,
+			IsCompound: true, Bytes: exts.FullBytes})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = obj.setFields(fields)
+	if err != nil {
+		return nil, err
+	}
+
+	return obj.sign(alg, priv)
+}
+
+func signerOpts(alg x509.SignatureAlgorithm) (crypto.SignerOpts, error) {
+	switch alg {
+	case x509.MD5WithRSA:
+		return crypto.MD5, nil
+	case x509.SHA1WithRSA, x509.ECDSAWithSHA1:
+		return crypto.SHA1, nil
+	case x509.SHA256WithRSA, x509.ECDSAWithSHA256:
+		return crypto.SHA256, nil
+	case x509.SHA384WithRSA, x509.ECDSAWithSHA384:
+		return crypto.SHA384, nil
+	case x509.SHA512WithRSA, x509.ECDSAWithSHA512:
+		return crypto.SHA512, nil
+	case x509.SHA256WithRSAPSS:
+		return &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA256}, nil
+	case x509.SHA384WithRSAPSS:
+		return &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA384}, nil
+	case x509.SHA512WithRSAPSS:
+		return &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: crypto.SHA512}, nil
+	case x509.PureEd25519:
+		return crypto.Hash(0), nil
+	default:
+		return nil, fmt.Errorf("unsupported signature algorithm: %s", alg)
+	}
+}
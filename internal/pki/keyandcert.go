@@ -4,22 +4,36 @@ import (
 	"crypto"
 	"crypto/x509"
 	"encoding/pem"
+
+	"tls-tools/internal/config"
 )
 
 type KeyAndCert struct {
+	name         string
+	cfg          config.Cert
 	template     *x509.Certificate
 	parentCert   string
+	publicKey    crypto.PublicKey
 	privateKey   crypto.Signer
 	certificate  *x509.Certificate
 	keyDER       []byte
+	csrDER       []byte // the request the cert is issued for, if any
 	certDER      []byte
 	certChainDER [][]byte
+	crlDER       []byte
+	serials      *serialTracker
+	restored     bool
 }
 
 func (k KeyAndCert) GetPrivateKey() crypto.Signer {
 	return k.privateKey
 }
 
+// IsRestored tells whether the cert was loaded from the state directory rather than issued in this run.
+func (k KeyAndCert) IsRestored() bool {
+	return k.restored
+}
+
 func (k KeyAndCert) GetCertificate() *x509.Certificate {
 	return k.certificate
 }
@@ -61,3 +75,44 @@ func (k KeyAndCert) GetCertPEM() []byte {
 func (k KeyAndCert) GetCertChainDER() [][]byte {
 	return append([][]byte{k.certDER}, k.certChainDER...)
 }
+
+// GetIssuedSerials returns the serial numbers (in hex) of the certs issued by this entry, along with the names of
+// the certs that have each one.
+func (k KeyAndCert) GetIssuedSerials() map[string][]string {
+	return k.serials.copyIssued()
+}
+
+func (k KeyAndCert) GetConfig() config.Cert {
+	return k.cfg
+}
+
+func (k KeyAndCert) GetCRLDER() []byte {
+	return k.crlDER
+}
+
+func (k KeyAndCert) GetCRLPEM() []byte {
+	if len(k.crlDER) == 0 {
+		return nil
+	}
+
+	return pem.EncodeToMemory(&pem.Block{
+		Type:  "X509 CRL",
+		Bytes: k.crlDER,
+	})
+}
+
+func (k KeyAndCert) GetName() string {
+	return k.name
+}
+
+func (k KeyAndCert) GetParentName() string {
+	return k.parentCert
+}
+
+// GetIntermediatesDER returns the certs between this one and its root, starting with its issuer.
+func (k KeyAndCert) GetIntermediatesDER() [][]byte {
+	if len(k.certChainDER) == 0 {
+		return nil
+	}
+	return k.certChainDER[:len(k.certChainDER)-1]
+}
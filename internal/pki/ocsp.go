@@ -0,0 +1,208 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"tls-tools/internal/config"
+)
+
+var oidOCSPNonce = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}
+
+// Just enough of an OCSP response to get at the signature
+
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response ocspResponseBytes `asn1:"explicit,tag:0,optional"`
+}
+
+type ocspResponseBytes struct {
+	ResponseType asn1.ObjectIdentifier
+	Response     []byte
+}
+
+type ocspBasicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm asn1.RawValue
+	Signature          asn1.BitString
+	Certs              asn1.RawValue `asn1:"optional"`
+}
+
+// NewOCSPResponse creates an OCSP response (in DER form) about the cert with the requested serial number issued by
+// the named CA. The nonce, if any, is the value of the request's nonce extension and is echoed in the response.
+func (s *Store) NewOCSPResponse(caName string, req *ocsp.Request, nonce []byte, opts config.OCSPResponse) ([]byte, error) {
+	ca, ok := (*s)[caName]
+	if !ok {
+		return nil, fmt.Errorf("failed to find cert named %s", caName)
+	}
+
+	status := strings.ToLower(strings.TrimSpace(opts.Status))
+	switch status {
+	case "trylater":
+		return ocsp.TryLaterErrorResponse, nil
+	case "unauthorized":
+		return ocsp.UnauthorizedErrorResponse, nil
+	}
+
+	responder := ca
+	if opts.Responder != "" {
+		responder, ok = (*s)[opts.Responder]
+		if !ok {
+			return nil, fmt.Errorf("failed to find cert named %s", opts.Responder)
+		}
+	}
+	signer := responder.privateKey
+	if opts.Signer != "" {
+		other, ok := (*s)[opts.Signer]
+		if !ok {
+			return nil, fmt.Errorf("failed to find cert named %s", opts.Signer)
+		}
+		signer = other.privateKey
+	}
+	if signer == nil {
+		return nil, fmt.Errorf("%s: cannot sign an OCSP response without a private key", caName)
+	}
+
+	tmpl := ocsp.Response{SerialNumber: req.SerialNumber, IssuerHash: req.HashAlgorithm}
+	err := s.setOCSPStatus(&tmpl, ca, status)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpl.ThisUpdate, tmpl.NextUpdate, err = opts.Validity()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", caName, err)
+	}
+	tmpl.ThisUpdate = tmpl.ThisUpdate.Truncate(time.Second)
+	tmpl.NextUpdate = tmpl.NextUpdate.Truncate(time.Second)
+
+	if nonce != nil {
+		tmpl.ExtraExtensions = []pkix.Extension{{Id: oidOCSPNonce, Value: nonce}}
+	}
+	// A delegated responder has to include its cert
+	if responder.name != ca.name {
+		tmpl.Certificate = responder.certificate
+	}
+
+	der, err := ocsp.CreateResponse(ca.certificate, responder.certificate, tmpl, signer)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", caName, err)
+	}
+	if opts.BadSignature {
+		return corruptOCSPSignature(der)
+	}
+	return der, nil
+}
+
+// setOCSPStatus sets the status of the response's serial number: forced by status, or else revoked if the config says
+// so, good if the CA issued it, and unknown otherwise. Revocation times default to the cert's notBefore, or the CA's for
+// a serial that isn't in the store.
+func (s *Store) setOCSPStatus(tmpl *ocsp.Response, ca KeyAndCert, status string) error {
+	var revoked *config.Revocation
+	issued := false
+	notBefore := ca.certificate.NotBefore
+	for _, c := range *s {
+		if c.parentCert == ca.name && c.certificate.SerialNumber.Cmp(tmpl.SerialNumber) == 0 {
+			issued = true
+			revoked = c.cfg.Revoked
+			notBefore = c.certificate.NotBefore
+		}
+	}
+	if !issued && ca.serials != nil {
+		_, issued = ca.serials.copyIssued()[serialKey(tmpl.SerialNumber)]
+	}
+
+	if status == "" {
+		switch {
+		case revoked != nil:
+			status = "revoked"
+		case issued:
+			status = "good"
+		default:
+			status = "unknown"
+		}
+	}
+
+	switch status {
+	case "good":
+		tmpl.Status = ocsp.Good
+	case "unknown":
+		tmpl.Status = ocsp.Unknown
+	case "revoked":
+		if revoked == nil {
+			revoked = &config.Revocation{}
+		}
+		tmpl.Status = ocsp.Revoked
+		t, err := revoked.RevokedAt(notBefore)
+		if err != nil {
+			return err
+		}
+		tmpl.RevokedAt = t.Truncate(time.Second)
+		code, ok, err := revoked.ReasonCode()
+		if err != nil {
+			return err
+		}
+		if ok {
+			tmpl.RevocationReason = code
+		}
+	default:
+		return fmt.Errorf("invalid OCSP status: %s", status)
+	}
+	return nil
+}
+
+// IssuerHashes returns the hashes of a CA's subject and public key that identify it in OCSP requests.
+func IssuerHashes(issuer *x509.Certificate, hash crypto.Hash) ([]byte, []byte, error) {
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	_, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	h := hash.New()
+	h.Write(issuer.RawSubject)
+	nameHash := h.Sum(nil)
+
+	h.Reset()
+	h.Write(spki.PublicKey.RightAlign())
+	keyHash := h.Sum(nil)
+
+	return nameHash, keyHash, nil
+}
+
+// corruptOCSPSignature flips a bit in the signature of a successful OCSP response, keeping any certs that come after it.
+func corruptOCSPSignature(der []byte) ([]byte, error) {
+	var resp ocspResponse
+	_, err := asn1.Unmarshal(der, &resp)
+	if err != nil {
+		return nil, err
+	}
+	var basic ocspBasicResponse
+	_, err = asn1.Unmarshal(resp.Response.Response, &basic)
+	if err != nil {
+		return nil, err
+	}
+	if len(basic.Signature.Bytes) == 0 {
+		return nil, errors.New("missing signature")
+	}
+
+	basic.Signature.Bytes = append([]byte{}, basic.Signature.Bytes...)
+	basic.Signature.Bytes[len(basic.Signature.Bytes)/2] ^= 0x01
+
+	resp.Response.Response, err = asn1.Marshal(basic)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(resp)
+}
@@ -0,0 +1,102 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/x509"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ocsp"
+
+	"tls-tools/internal/config"
+)
+
+func TestStore_NewOCSPResponse(t *testing.T) {
+	ocspSigning := "ocspSigning"
+	store, err := NewStoreFromConfig(map[string]config.Cert{
+		"ca":        {KeyType: "P-256", Purpose: "root-ca"},
+		"responder": {KeyType: "P-384", Parent: "ca", ExtKeyUsage: &ocspSigning},
+		"revoked":   {KeyType: "P-256", Parent: "ca", Revoked: &config.Revocation{Reason: "keyCompromise"}},
+		"good":      {KeyType: "P-256", Parent: "ca"},
+	})
+	assert.Nil(t, err)
+	ca := store["ca"].GetCertificate()
+
+	req := &ocsp.Request{HashAlgorithm: crypto.SHA256, SerialNumber: store["good"].GetCertificate().SerialNumber}
+	der, err := store.NewOCSPResponse("ca", req, []byte{0x04, 0x02, 0x01, 0x02}, config.OCSPResponse{})
+	assert.Nil(t, err)
+	resp, err := ocsp.ParseResponseForCert(der, store["good"].GetCertificate(), ca)
+	assert.Nil(t, err)
+	assert.Equal(t, ocsp.Good, resp.Status)
+	assert.True(t, resp.NextUpdate.After(time.Now()))
+	assert.Equal(t, []byte{0x04, 0x02, 0x01, 0x02}, ocspNonce(t, der))
+
+	req.SerialNumber = store["revoked"].GetCertificate().SerialNumber
+	der, err = store.NewOCSPResponse("ca", req, nil, config.OCSPResponse{Responder: "responder"})
+	assert.Nil(t, err)
+	resp, err = ocsp.ParseResponseForCert(der, store["revoked"].GetCertificate(), ca)
+	assert.Nil(t, err)
+	assert.Equal(t, ocsp.Revoked, resp.Status)
+	assert.Equal(t, ocsp.KeyCompromise, resp.RevocationReason)
+	assert.Equal(t, store["responder"].GetCertDER(), resp.Certificate.Raw)
+	// the same time as on the CRL
+	assert.Equal(t, store["revoked"].GetCertificate().NotBefore, resp.RevokedAt)
+	crl, err := x509.ParseRevocationList(store["ca"].GetCRLDER())
+	assert.Nil(t, err)
+	if assert.Len(t, crl.RevokedCertificateEntries, 1) {
+		assert.Equal(t, resp.RevokedAt, crl.RevokedCertificateEntries[0].RevocationTime)
+	}
+
+	req.SerialNumber = big.NewInt(12345)
+	der, err = store.NewOCSPResponse("ca", req, nil, config.OCSPResponse{})
+	assert.Nil(t, err)
+	resp, err = ocsp.ParseResponse(der, ca)
+	assert.Nil(t, err)
+	assert.Equal(t, ocsp.Unknown, resp.Status)
+
+	der, err = store.NewOCSPResponse("ca", req, nil, config.OCSPResponse{Status: "tryLater"})
+	assert.Nil(t, err)
+	_, err = ocsp.ParseResponse(der, ca)
+	assert.Equal(t, ocsp.ResponseError{Status: ocsp.TryLater}, err)
+}
+
+func TestStore_NewOCSPResponse_broken(t *testing.T) {
+	store, err := NewStoreFromConfig(map[string]config.Cert{
+		"ca":    {KeyType: "RSA-2048", Purpose: "root-ca"},
+		"other": {KeyType: "P-256", Purpose: "root-ca"},
+		"good":  {KeyType: "P-256", Parent: "ca"},
+	})
+	assert.Nil(t, err)
+	ca := store["ca"].GetCertificate()
+	req := &ocsp.Request{SerialNumber: store["good"].GetCertificate().SerialNumber}
+
+	der, err := store.NewOCSPResponse("ca", req, nil, config.OCSPResponse{Expired: true, Status: "revoked"})
+	assert.Nil(t, err)
+	resp, err := ocsp.ParseResponse(der, ca)
+	assert.Nil(t, err)
+	assert.Equal(t, ocsp.Revoked, resp.Status)
+	assert.True(t, resp.NextUpdate.Before(time.Now()))
+
+	der, err = store.NewOCSPResponse("ca", req, nil, config.OCSPResponse{Signer: "other"})
+	assert.Nil(t, err)
+	_, err = ocsp.ParseResponse(der, ca)
+	assert.NotNil(t, err)
+
+	der, err = store.NewOCSPResponse("ca", req, nil, config.OCSPResponse{BadSignature: true})
+	assert.Nil(t, err)
+	_, err = ocsp.ParseResponse(der, ca)
+	assert.NotNil(t, err)
+}
+
+func ocspNonce(t *testing.T, der []byte) []byte {
+	resp, err := ocsp.ParseResponse(der, nil)
+	assert.Nil(t, err)
+	for _, ext := range resp.Extensions {
+		if ext.Id.Equal(oidOCSPNonce) {
+			return ext.Value
+		}
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package pki
+
+import (
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/random"
+)
+
+const defaultSerialLength = 18
+
+type serialTracker struct {
+	mu     sync.Mutex
+	policy config.SerialPolicy
+	next   *big.Int
+	issued map[string][]string
+}
+
+func newSerialTracker(p *config.SerialPolicy) (*serialTracker, error) {
+	t := serialTracker{issued: make(map[string][]string)}
+	if p != nil {
+		t.policy = *p
+	}
+
+	switch strings.ToLower(strings.TrimSpace(t.policy.Type)) {
+	case "", "random":
+		if t.policy.Length == 0 {
+			t.policy.Length = defaultSerialLength
+		}
+		if t.policy.Length < 1 {
+			return nil, fmt.Errorf("invalid serial number length: %d", t.policy.Length)
+		}
+	case "sequential":
+		t.next = big.NewInt(1)
+		if t.policy.Start != nil {
+			var ok bool
+			t.next, ok = t.policy.Start.ToBigInt()
+			if !ok {
+				return nil, fmt.Errorf("invalid serial number: %s", *t.policy.Start)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("invalid serial number policy: %s", t.policy.Type)
+	}
+
+	return &t, nil
+}
+
+func (t *serialTracker) nextSerial() *big.Int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.next != nil {
+		sn := big.NewInt(0).Set(t.next)
+		t.next.Add(t.next, big.NewInt(1))
+		return sn
+	}
+
+	b := random.Bytes(t.policy.Length)
+	switch {
+	case t.policy.Negative:
+		// Keep the encoding at exactly Length octets (0xff followed by a set high bit would be shortened)
+		b[0] |= 0x80
+		if b[0] == 0xff {
+			b[0] = 0xfe
+		}
+		sn := big.NewInt(0).SetBytes(b)
+		return sn.Sub(sn, big.NewInt(0).Lsh(big.NewInt(1), uint(8*len(b))))
+	case t.policy.HighBit:
+		// The encoding gets a leading zero, so it's Length+1 octets long
+		b[0] |= 0x80
+	default:
+		b[0] &= 0x7f
+		if b[0] == 0 {
+			b[0] = 1
+		}
+	}
+	return big.NewInt(0).SetBytes(b)
+}
+
+func (t *serialTracker) record(serial *big.Int, name string, duplicate bool) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	key := serialKey(serial)
+	if prev := t.issued[key]; len(prev) > 0 && !duplicate {
+		return fmt.Errorf("duplicate serial number %s (%s and %s)", key, prev[0], name)
+	}
+	t.issued[key] = append(t.issued[key], name)
+	return nil
+}
+
+// restore records a serial from an earlier run, and makes sure a sequential policy doesn't hand it out again.
+func (t *serialTracker) restore(serial *big.Int, name string, duplicate bool) error {
+	err := t.record(serial, name, duplicate)
+	if err != nil {
+		return err
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.next != nil && serial.Cmp(t.next) >= 0 {
+		t.next = big.NewInt(0).Add(serial, big.NewInt(1))
+	}
+	return nil
+}
+
+func (t *serialTracker) copyIssued() map[string][]string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	issued := make(map[string][]string, len(t.issued))
+	for sn, names := range t.issued {
+		issued[sn] = append([]string{}, names...)
+	}
+	return issued
+}
+
+func serialKey(serial *big.Int) string {
+	return serial.Text(16)
+}
@@ -0,0 +1,116 @@
+package pki
+
+import (
+	"encoding/asn1"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"tls-tools/internal/config"
+)
+
+func TestSerialTracker_sequential(t *testing.T) {
+	start := config.HexString("ff")
+	tr, err := newSerialTracker(&config.SerialPolicy{Type: "sequential", Start: &start})
+	assert.Nil(t, err)
+	assert.Equal(t, big.NewInt(0xff), tr.nextSerial())
+	assert.Equal(t, big.NewInt(0x100), tr.nextSerial())
+	assert.Equal(t, big.NewInt(0x101), tr.nextSerial())
+}
+
+func TestSerialTracker_random(t *testing.T) {
+	tr, err := newSerialTracker(nil)
+	assert.Nil(t, err)
+	assertEncodedLength(t, 18, tr.nextSerial())
+
+	tr, err = newSerialTracker(&config.SerialPolicy{Length: 20})
+	assert.Nil(t, err)
+	sn := tr.nextSerial()
+	assert.Equal(t, 1, sn.Sign())
+	assertEncodedLength(t, 20, sn)
+
+	tr, err = newSerialTracker(&config.SerialPolicy{Length: 20, HighBit: true})
+	assert.Nil(t, err)
+	sn = tr.nextSerial()
+	assert.Equal(t, 1, sn.Sign())
+	assertEncodedLength(t, 21, sn)
+
+	tr, err = newSerialTracker(&config.SerialPolicy{Length: 20, Negative: true})
+	assert.Nil(t, err)
+	sn = tr.nextSerial()
+	assert.Equal(t, -1, sn.Sign())
+	assertEncodedLength(t, 20, sn)
+}
+
+func TestSerialTracker_invalid(t *testing.T) {
+	_, err := newSerialTracker(&config.SerialPolicy{Type: "bogus"})
+	assert.NotNil(t, err)
+
+	_, err = newSerialTracker(&config.SerialPolicy{Length: -1})
+	assert.NotNil(t, err)
+}
+
+func TestSerialTracker_duplicates(t *testing.T) {
+	tr, err := newSerialTracker(nil)
+	assert.Nil(t, err)
+	assert.Nil(t, tr.record(big.NewInt(1), "a", false))
+	assert.NotNil(t, tr.record(big.NewInt(1), "b", false))
+	assert.Nil(t, tr.record(big.NewInt(1), "c", true))
+	assert.Equal(t, map[string][]string{"1": {"a", "c"}}, tr.copyIssued())
+}
+
+func TestNewStoreFromConfig_serials(t *testing.T) {
+	start := config.HexString("1000")
+	store, err := NewStoreFromConfig(map[string]config.Cert{
+		"ca":   {KeyType: "P-256", Purpose: "root-ca", Serials: &config.SerialPolicy{Type: "sequential", Start: &start}},
+		"b":    {KeyType: "P-256", Parent: "ca"},
+		"a":    {KeyType: "P-256", Parent: "ca"},
+		"a2":   {KeyType: "P-256", Parent: "ca", SameSerialAs: "a"},
+		"neg":  {KeyType: "P-256", Purpose: "root-ca", Serials: &config.SerialPolicy{Negative: true, Length: 8}},
+		"leaf": {KeyType: "P-256", Parent: "neg"},
+	})
+	assert.Nil(t, err)
+
+	assert.Equal(t, big.NewInt(0x1000), store["ca"].GetCertificate().SerialNumber)
+	assert.Equal(t, big.NewInt(0x1001), store["a"].GetCertificate().SerialNumber)
+	assert.Equal(t, big.NewInt(0x1001), store["a2"].GetCertificate().SerialNumber)
+	assert.Equal(t, big.NewInt(0x1002), store["b"].GetCertificate().SerialNumber)
+	assert.Len(t, store["ca"].GetIssuedSerials()["1001"], 2)
+
+	leaf := store["leaf"].GetCertificate()
+	assert.Equal(t, -1, leaf.SerialNumber.Sign())
+	assert.Nil(t, leaf.CheckSignatureFrom(store["neg"].GetCertificate()))
+}
+
+func TestNewStoreFromConfig_duplicateSerials(t *testing.T) {
+	serial := config.HexString("01")
+	_, err := NewStoreFromConfig(map[string]config.Cert{
+		"ca": {KeyType: "P-256", Purpose: "root-ca"},
+		"a":  {KeyType: "P-256", Parent: "ca", SerialNumber: &serial},
+		"b":  {KeyType: "P-256", Parent: "ca", SerialNumber: &serial},
+	})
+	assert.NotNil(t, err)
+}
+
+func TestNewStoreFromConfig_sameSerialAsOtherIssuer(t *testing.T) {
+	_, err := NewStoreFromConfig(map[string]config.Cert{
+		"ca":    {KeyType: "P-256", Purpose: "root-ca"},
+		"other": {KeyType: "P-256", Purpose: "root-ca"},
+		"a":     {KeyType: "P-256", Parent: "ca"},
+		"b":     {KeyType: "P-256", Parent: "other", SameSerialAs: "a"},
+	})
+	assert.ErrorContains(t, err, "same issuer")
+
+	_, err = NewStoreFromConfig(map[string]config.Cert{
+		"ca":    {KeyType: "P-256", Purpose: "root-ca"},
+		"other": {KeyType: "P-256", Purpose: "root-ca", SameSerialAs: "ca"},
+	})
+	assert.ErrorContains(t, err, "same issuer")
+}
+
+func assertEncodedLength(t *testing.T, expected int, serial *big.Int) {
+	der, err := asn1.Marshal(serial)
+	assert.Nil(t, err)
+	assert.Len(t, der[2:], expected)
+}
@@ -0,0 +1,25 @@
+package pki
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Slug turns the name of a cert into something that's safe to use in file names and URLs: "Root CA" becomes
+// "root-ca".
+func Slug(name string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(name) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r) || r == '.' || r == '_') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,14 @@
+package pki
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlug(t *testing.T) {
+	assert.Equal(t, "root-ca", Slug("Root CA"))
+	assert.Equal(t, "intermediate-ca-2", Slug("  Intermediate CA #2 "))
+	assert.Equal(t, "bar1.example.com", Slug("bar1.example.com"))
+	assert.Equal(t, "caf", Slug("café"))
+}
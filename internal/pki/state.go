@@ -0,0 +1,290 @@
+package pki
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+
+	"tls-tools/internal/config"
+)
+
+const (
+	stateFile = "state.json"
+	lockFile  = "state.lock"
+	// lockTimeout is how long LoadStore waits for another command to finish with the state directory.
+	lockTimeout = 30 * time.Second
+)
+
+// savedEntry is what the state directory keeps for each cert: its key and cert, along with a hash of the config they
+// came from.
+type savedEntry struct {
+	ConfigHash string `json:"configHash"`
+	Key        []byte `json:"key,omitempty"` // PKCS#8
+	Cert       []byte `json:"cert"`
+}
+
+// StateOptions change how LoadStore treats the saved state.
+type StateOptions struct {
+	Ignore      bool          // generate everything again
+	RenewWithin time.Duration // reissue certs that expire within this long, even if their config hasn't changed; expired ones always are
+	NewKeys     bool          // give reissued certs new keys; otherwise they keep their keys and subjects
+}
+
+// LoadStore is NewStoreFromConfig with a state directory shared between runs and commands. Keys and certs saved there
+// are reused as long as their config hasn't changed; the ones that did change are generated again, along with
+// everything they issued, and the result is saved for the next run. An empty dir means no state.
+func LoadStore(cfg map[string]config.Cert, dir string, opts StateOptions) (Store, error) {
+	if dir == "" {
+		return NewStoreFromConfig(cfg)
+	}
+
+	unlock, err := lockState(dir)
+	if err != nil {
+		return nil, err
+	}
+	defer unlock()
+
+	var saved map[string]savedEntry
+	if !opts.Ignore {
+		saved, err = readState(dir)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	store, err := newStore(cfg, saved, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return store, store.save(dir)
+}
+
+// lockState creates the state directory's lock file, waiting for another command to remove it if need be, so that
+// commands sharing the directory don't both generate certs and then overwrite each other's state. It returns a
+// function that removes the lock file.
+func lockState(dir string) (func(), error) {
+	err := mkdirState(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	path := filepath.Join(dir, lockFile)
+	deadline := time.Now().Add(lockTimeout)
+	for {
+		f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			_ = f.Close()
+			return func() { _ = os.Remove(path) }, nil
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, err
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("%s is held by another command; remove it if none is running", path)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// mkdirState creates the state directory readable only by its owner, since it holds private keys. Its parents (often the
+// output directory) get the usual permissions.
+func mkdirState(dir string) error {
+	err := os.MkdirAll(filepath.Dir(dir), 0755)
+	if err != nil {
+		return err
+	}
+	err = os.Mkdir(dir, 0700)
+	if errors.Is(err, fs.ErrExist) {
+		return nil
+	}
+	return err
+}
+
+func readState(dir string) (map[string]savedEntry, error) {
+	b, err := os.ReadFile(filepath.Join(dir, stateFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var saved map[string]savedEntry
+	err = json.Unmarshal(b, &saved)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", stateFile, err)
+	}
+	return saved, nil
+}
+
+// save writes the state to a temporary file first, so that another command never reads half of it.
+func (s Store) save(dir string) error {
+	saved := make(map[string]savedEntry, len(s))
+	for name, kac := range s {
+		saved[name] = savedEntry{ConfigHash: configHash(kac.cfg, kac.csrDER), Key: kac.keyDER, Cert: kac.certDER}
+	}
+	b, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = mkdirState(dir)
+	if err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, stateFile+".*")
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(b)
+	if err == nil {
+		err = f.Close()
+	} else {
+		_ = f.Close()
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filepath.Join(dir, stateFile))
+	}
+	if err != nil {
+		_ = os.Remove(f.Name())
+	}
+	return err
+}
+
+// configHash covers the parts of an entry's config that go into its key and cert, along with the CSR it was issued
+// for, if any; the rest (like its CRL or the files written for it) can change without generating it again.
+func configHash(c config.Cert, csrDER []byte) string {
+	c.Request = nil
+	c.Revoked = nil
+	c.CRL = nil
+	c.PKCS12 = nil
+	c.JKS = nil
+	c.KeyFormat = nil
+
+	b, _ := json.Marshal(c)
+	h := sha256.New()
+	h.Write(b)
+	h.Write(csrDER)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// keyFor returns the saved key if the config hasn't changed and the cert isn't being renewed with a new key, or nil.
+// Entries with a CSR have no key saved, so their CSR doesn't come into it.
+func (e savedEntry) keyFor(c config.Cert, opts StateOptions) (crypto.Signer, error) {
+	if e.Key == nil || e.ConfigHash != configHash(c, nil) || e.newKey(opts) {
+		return nil, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(e.Key)
+	if err != nil {
+		return nil, err
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type: %T", key)
+	}
+	return signer, nil
+}
+
+// newKey tells whether a cert that's being renewed gets a new key.
+func (e savedEntry) newKey(opts StateOptions) bool {
+	return opts.NewKeys && e.expiresWithin(opts.RenewWithin)
+}
+
+// needsRenewal tells whether a saved cert has to be reissued although its config is unchanged: it expires within
+// RenewWithin, or it has already expired.
+func (e savedEntry) needsRenewal(opts StateOptions) bool {
+	crt, err := x509.ParseCertificate(e.Cert)
+	return e.expiresWithin(opts.RenewWithin) || (err == nil && !time.Now().Before(crt.NotAfter))
+}
+
+func (e savedEntry) expiresWithin(d time.Duration) bool {
+	if d <= 0 {
+		return false
+	}
+	crt, err := x509.ParseCertificate(e.Cert)
+	return err == nil && time.Until(crt.NotAfter) < d
+}
+
+// restoreCerts puts back the saved certs whose config is unchanged and whose issuer was restored, or renewed with the
+// same key and subject. It goes from the roots down, so that a cert whose issuer changed is signed again, with the
+// same key. Certs that share another's serial come last, and only if that one was restored.
+func (s *Store) restoreCerts(saved map[string]savedEntry, opts StateOptions) error {
+	// entries whose certs are still valid issuers for the saved certs below them
+	kept := make(map[string]bool)
+
+	order := s.signingOrder()
+	for _, sameSerial := range []bool{false, true} {
+		for _, name := range order {
+			if ((*s)[name].cfg.SameSerialAs != "") != sameSerial {
+				continue
+			}
+			err := s.restoreCert(name, saved[name], opts, kept)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (s *Store) restoreCert(name string, e savedEntry, opts StateOptions, kept map[string]bool) error {
+	c := (*s)[name]
+	if e.Cert == nil || e.ConfigHash != configHash(c.cfg, c.csrDER) || (c.cfg.CSR == "" && c.privateKey == nil) {
+		return nil
+	}
+	if c.cfg.SameSerialAs != "" && (*s)[c.cfg.SameSerialAs].certDER == nil {
+		return nil
+	}
+
+	issuer := c
+	if c.parentCert != "" {
+		var ok bool
+		issuer, ok = (*s)[c.parentCert]
+		if !ok || !kept[c.parentCert] {
+			return nil
+		}
+	}
+
+	crt, err := x509.ParseCertificate(e.Cert)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+
+	if e.needsRenewal(opts) {
+		// A renewed cert with the same key and subject still verifies the certs it issued
+		if !e.newKey(opts) || c.cfg.CSR != "" {
+			if c.cfg.Subject == nil {
+				c.template.Subject = crt.Subject
+			}
+			kept[name] = true
+			(*s)[name] = c
+		}
+		return nil
+	}
+
+	if c.cfg.CSR != "" {
+		c.publicKey = crt.PublicKey
+	}
+	c.certDER = e.Cert
+	c.certificate = crt
+	c.template = nil
+	c.restored = true
+
+	err = issuer.serials.restore(crt.SerialNumber, name, c.cfg.SameSerialAs != "")
+	if err != nil {
+		return fmt.Errorf("%s: %w", issuer.name, err)
+	}
+	kept[name] = true
+	(*s)[name] = c
+	return nil
+}
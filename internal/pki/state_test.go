@@ -0,0 +1,187 @@
+package pki
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"tls-tools/internal/config"
+)
+
+func TestLoadStore(t *testing.T) {
+	dir := t.TempDir()
+	cfg := map[string]config.Cert{
+		"root":  {KeyType: "P-256", Purpose: "root-ca", Serials: &config.SerialPolicy{Type: "sequential"}},
+		"leaf":  {KeyType: "P-256", Parent: "root"},
+		"other": {KeyType: "P-256", Parent: "root"},
+	}
+
+	first, err := LoadStore(cfg, dir, StateOptions{})
+	assert.Nil(t, err)
+
+	// nothing changed
+	second, err := LoadStore(cfg, dir, StateOptions{})
+	assert.Nil(t, err)
+	for name := range cfg {
+		assert.Equal(t, first[name].GetCertDER(), second[name].GetCertDER(), name)
+		assert.Equal(t, first[name].GetKeyDER(), second[name].GetKeyDER(), name)
+	}
+
+	// a changed leaf gets a new key and a serial that isn't taken
+	cfg["leaf"] = config.Cert{KeyType: "P-256", Parent: "root", DNSNames: []string{"example.com"}}
+	third, err := LoadStore(cfg, dir, StateOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, second["root"].GetCertDER(), third["root"].GetCertDER())
+	assert.Equal(t, second["other"].GetCertDER(), third["other"].GetCertDER())
+	assert.NotEqual(t, second["leaf"].GetKeyDER(), third["leaf"].GetKeyDER())
+	// 1 is the root itself, then leaf and other
+	assert.Equal(t, "4", third["leaf"].GetCertificate().SerialNumber.String())
+
+	// a changed root is reissued, and so is everything below it, with the same keys
+	cfg["root"] = config.Cert{KeyType: "P-256", Purpose: "root-ca", NotAfter: "+24h"}
+	fourth, err := LoadStore(cfg, dir, StateOptions{})
+	assert.Nil(t, err)
+	assert.NotEqual(t, third["root"].GetKeyDER(), fourth["root"].GetKeyDER())
+	assert.NotEqual(t, third["other"].GetCertDER(), fourth["other"].GetCertDER())
+	assert.Equal(t, third["other"].GetKeyDER(), fourth["other"].GetKeyDER())
+	assert.Nil(t, fourth["other"].GetCertificate().CheckSignatureFrom(fourth["root"].GetCertificate()))
+}
+
+func TestLoadStore_renew(t *testing.T) {
+	dir := t.TempDir()
+	cfg := map[string]config.Cert{
+		"root": {KeyType: "P-256", Purpose: "root-ca", NotAfter: "+240h"},
+		"leaf": {KeyType: "P-256", Parent: "root", NotAfter: "+720h"},
+	}
+
+	first, err := LoadStore(cfg, dir, StateOptions{})
+	assert.Nil(t, err)
+
+	// the root is renewed with the same key and subject, so the leaf can stay
+	second, err := LoadStore(cfg, dir, StateOptions{RenewWithin: 20 * 24 * time.Hour})
+	assert.Nil(t, err)
+	assert.NotEqual(t, first["root"].GetCertDER(), second["root"].GetCertDER())
+	assert.Equal(t, first["root"].GetKeyDER(), second["root"].GetKeyDER())
+	assert.Equal(t, first["root"].GetCertificate().Subject, second["root"].GetCertificate().Subject)
+	assert.True(t, second["leaf"].IsRestored())
+	assert.Nil(t, second["leaf"].GetCertificate().CheckSignatureFrom(second["root"].GetCertificate()))
+	assert.Equal(t, second["root"].GetCertDER(), second["leaf"].GetCertChainDER()[1])
+
+	// with new keys, the leaf has to be reissued too
+	third, err := LoadStore(cfg, dir, StateOptions{RenewWithin: 20 * 24 * time.Hour, NewKeys: true})
+	assert.Nil(t, err)
+	assert.NotEqual(t, second["root"].GetKeyDER(), third["root"].GetKeyDER())
+	assert.False(t, third["leaf"].IsRestored())
+	assert.Equal(t, second["leaf"].GetKeyDER(), third["leaf"].GetKeyDER())
+	assert.Nil(t, third["leaf"].GetCertificate().CheckSignatureFrom(third["root"].GetCertificate()))
+}
+
+func TestLoadStore_expired(t *testing.T) {
+	dir := t.TempDir()
+	cfg := map[string]config.Cert{
+		"root": {KeyType: "P-256", Purpose: "root-ca"},
+		"leaf": {KeyType: "P-256", Parent: "root", NotAfter: "+1s"},
+	}
+
+	first, err := LoadStore(cfg, dir, StateOptions{})
+	assert.Nil(t, err)
+	time.Sleep(time.Until(first["leaf"].GetCertificate().NotAfter) + 100*time.Millisecond)
+
+	// the leaf has expired, so it's reissued with the same key even though nothing asked for renewals
+	second, err := LoadStore(cfg, dir, StateOptions{NewKeys: true})
+	assert.Nil(t, err)
+	assert.True(t, second["root"].IsRestored())
+	assert.False(t, second["leaf"].IsRestored())
+	assert.NotEqual(t, first["leaf"].GetCertDER(), second["leaf"].GetCertDER())
+	assert.Equal(t, first["leaf"].GetKeyDER(), second["leaf"].GetKeyDER())
+	assert.Equal(t, first["leaf"].GetCertificate().Subject, second["leaf"].GetCertificate().Subject)
+}
+
+func TestLoadStore_concurrent(t *testing.T) {
+	dir := t.TempDir()
+	cfg := map[string]config.Cert{
+		"root": {KeyType: "P-256", Purpose: "root-ca"},
+		"leaf": {KeyType: "P-256", Parent: "root"},
+	}
+
+	// without the lock, each would generate its own certs and the last to save would win
+	stores := make([]Store, 5)
+	var wg sync.WaitGroup
+	for i := range stores {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var err error
+			stores[i], err = LoadStore(cfg, dir, StateOptions{})
+			assert.Nil(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	last, err := LoadStore(cfg, dir, StateOptions{})
+	assert.Nil(t, err)
+	for _, store := range stores {
+		if assert.NotNil(t, store) {
+			assert.Equal(t, last["leaf"].GetCertDER(), store["leaf"].GetCertDER())
+		}
+	}
+	_, err = os.Stat(filepath.Join(dir, lockFile))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLoadStore_csrChanged(t *testing.T) {
+	dir := t.TempDir()
+	csrFile := filepath.Join(t.TempDir(), "leaf.csr")
+	writeCSR := func() {
+		store, err := NewStoreFromConfig(map[string]config.Cert{"leaf": {KeyType: "P-256"}})
+		assert.Nil(t, err)
+		der, err := NewCSR(store["leaf"], config.Request{})
+		assert.Nil(t, err)
+		assert.Nil(t, os.WriteFile(csrFile, der, 0600))
+	}
+	cfg := map[string]config.Cert{
+		"root": {KeyType: "P-256", Purpose: "root-ca"},
+		"leaf": {Parent: "root", CSR: csrFile},
+	}
+
+	writeCSR()
+	first, err := LoadStore(cfg, dir, StateOptions{})
+	assert.Nil(t, err)
+	second, err := LoadStore(cfg, dir, StateOptions{})
+	assert.Nil(t, err)
+	assert.True(t, second["leaf"].IsRestored())
+
+	// same path, new key
+	writeCSR()
+	third, err := LoadStore(cfg, dir, StateOptions{})
+	assert.Nil(t, err)
+	assert.False(t, third["leaf"].IsRestored())
+	assert.NotEqual(t, first["leaf"].GetCertificate().PublicKey, third["leaf"].GetCertificate().PublicKey)
+}
+
+func TestLoadStore_permissions(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out")
+	dir := filepath.Join(out, ".mkcerts")
+	cfg := map[string]config.Cert{"root": {KeyType: "P-256", Purpose: "root-ca"}}
+
+	_, err := LoadStore(cfg, dir, StateOptions{})
+	assert.Nil(t, err)
+
+	// only the state dir is private; the output dir around it is like any other (subject to the umask)
+	other := filepath.Join(t.TempDir(), "other")
+	assert.Nil(t, os.Mkdir(other, 0755))
+	want, err := os.Stat(other)
+	assert.Nil(t, err)
+	info, err := os.Stat(out)
+	if assert.Nil(t, err) {
+		assert.Equal(t, want.Mode().Perm(), info.Mode().Perm())
+	}
+	info, err = os.Stat(dir)
+	if assert.Nil(t, err) {
+		assert.Equal(t, os.FileMode(0700), info.Mode().Perm())
+	}
+}
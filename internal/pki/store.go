@@ -1,6 +1,7 @@
 package pki
 
 import (
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/ed25519"
 	"crypto/elliptic"
@@ -12,6 +13,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"sort"
 
 	"tls-tools/internal/config"
 )
@@ -19,62 +21,169 @@ import (
 type Store map[string]KeyAndCert
 
 func NewStoreFromConfig(cfg map[string]config.Cert) (Store, error) {
+	return newStore(cfg, nil, StateOptions{})
+}
+
+// newStore generates the keys and certs in cfg, except for those it can restore from saved.
+func newStore(cfg map[string]config.Cert, saved map[string]savedEntry, opts StateOptions) (Store, error) {
 	store := Store{}
-	needSignatures := make([]string, 0)
 
 	for name, crt := range cfg {
-		tmpl, err := crt.ToTemplate()
+		key, err := saved[name].keyFor(crt, opts)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		kac, err := newKeyAndCert(name, crt, nil, key)
 		if err != nil {
 			return nil, err
 		}
+		store[name] = kac
+	}
 
-		priv, err := NewKeypair(crt.GetKeyType())
+	err := store.restoreCerts(saved, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, name := range store.signingOrder() {
+		err := store.signCertAndAncestors(name, maxChainLength)
 		if err != nil {
 			return nil, err
 		}
-		tmpl.PublicKey = priv.Public()
+	}
+	store.linkChains()
+
+	err = store.createCRLs()
+	if err != nil {
+		return nil, err
+	}
+
+	return store, nil
+}
+
+const maxChainLength = 5
+
+// SignCSR issues a cert for the key in csr, signed by the named CA. Fields set in the profile take precedence over
+// the subject and SANs requested in the CSR.
+func (s *Store) SignCSR(name string, csr *x509.CertificateRequest, profile config.Cert, caName string) (KeyAndCert, error) {
+	if caName != "" {
+		profile.Parent = caName
+	}
+	if profile.Parent == "" {
+		return KeyAndCert{}, errors.New("no CA specified")
+	}
+
+	parent, ok := (*s)[profile.Parent]
+	if !ok {
+		return KeyAndCert{}, fmt.Errorf("failed to find cert named %s", profile.Parent)
+	}
+
+	c, err := newKeyAndCert(name, profile, csr, nil)
+	if err != nil {
+		return KeyAndCert{}, err
+	}
+
+	err = s.assignSerial(&c, parent, maxChainLength)
+	if err != nil {
+		return KeyAndCert{}, err
+	}
+
+	return sign(c, parent)
+}
+
+// newKeyAndCert prepares an entry for signing, with the key from the CSR, the given key, or else a new one.
+func newKeyAndCert(name string, crt config.Cert, csr *x509.CertificateRequest, key crypto.Signer) (KeyAndCert, error) {
+	tmpl, err := crt.ToTemplate()
+	if err != nil {
+		return KeyAndCert{}, err
+	}
+
+	kac := KeyAndCert{
+		name:       name,
+		cfg:        crt,
+		template:   tmpl,
+		parentCert: crt.Parent,
+	}
 
-		keyDer, err := x509.MarshalPKCS8PrivateKey(priv)
+	if csr == nil && crt.CSR != "" {
+		csr, err = ReadCSR(crt.CSR)
 		if err != nil {
-			return nil, err
+			return KeyAndCert{}, err
 		}
+	}
 
-		if crt.SubjectKeyId == nil {
-			pubBytes, err := marshalPublicKey(priv.Public())
+	if csr != nil {
+		applyCSR(tmpl, crt, csr)
+		kac.publicKey = csr.PublicKey
+		kac.csrDER = csr.Raw
+	} else {
+		kac.privateKey = key
+		if kac.privateKey == nil {
+			kac.privateKey, err = NewKeypair(crt.GetKeyType())
 			if err != nil {
-				return nil, err
+				return KeyAndCert{}, err
 			}
-			pubHash := sha1.Sum(pubBytes)
-			tmpl.SubjectKeyId = pubHash[:]
 		}
+		kac.publicKey = kac.privateKey.Public()
 
-		kac := KeyAndCert{
-			privateKey: priv,
-			keyDER:     keyDer,
-			template:   tmpl,
-			parentCert: crt.Parent,
+		kac.keyDER, err = x509.MarshalPKCS8PrivateKey(kac.privateKey)
+		if err != nil {
+			return KeyAndCert{}, err
 		}
+	}
+	tmpl.PublicKey = kac.publicKey
 
-		if kac.parentCert == "" {
-			kac, err = signSelf(kac)
-			if err != nil {
-				return nil, err
-			}
-		} else {
-			needSignatures = append(needSignatures, name)
+	if crt.SubjectKeyId == nil {
+		pubBytes, err := marshalPublicKey(kac.publicKey)
+		if err != nil {
+			return KeyAndCert{}, err
 		}
+		pubHash := sha1.Sum(pubBytes)
+		tmpl.SubjectKeyId = pubHash[:]
+	}
 
-		store[name] = kac
+	kac.serials, err = newSerialTracker(crt.Serials)
+	if err != nil {
+		return KeyAndCert{}, fmt.Errorf("%s: %w", name, err)
 	}
 
-	for _, name := range needSignatures {
-		err := store.signCertAndAncestors(name, 5)
-		if err != nil {
-			return nil, err
+	return kac, nil
+}
+
+// signingOrder sorts entries by their distance from a root and then by name, so that each CA issues sequential serial
+// numbers in a predictable order.
+func (s *Store) signingOrder() []string {
+	depths := make(map[string]int, len(*s))
+	names := make([]string, 0, len(*s))
+	for name, c := range *s {
+		d := 0
+		for p := c.parentCert; p != "" && d <= maxChainLength; p = (*s)[p].parentCert {
+			d++
 		}
+		depths[name] = d
+		names = append(names, name)
 	}
 
-	return store, nil
+	sort.Slice(names, func(i, j int) bool {
+		if depths[names[i]] != depths[names[j]] {
+			return depths[names[i]] < depths[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	return names
+}
+
+// linkChains sets each chain from its issuer's, from the roots down, which matters when a restored cert's issuer was
+// renewed after the cert was restored.
+func (s *Store) linkChains() {
+	for _, name := range s.signingOrder() {
+		c := (*s)[name]
+		if parent, ok := (*s)[c.parentCert]; ok {
+			c.certChainDER = append([][]byte{parent.certDER}, parent.certChainDER...)
+			(*s)[name] = c
+		}
+	}
 }
 
 func (s *Store) signCertAndAncestors(name string, maxDepth int) error {
@@ -87,19 +196,23 @@ func (s *Store) signCertAndAncestors(name string, maxDepth int) error {
 		return nil
 	}
 
+	if maxDepth < 0 {
+		return errors.New("failed to find root cert (chain too long)")
+	}
+
 	var err error
 	if c.parentCert == "" {
+		err = s.assignSerial(&c, c, maxDepth)
+		if err != nil {
+			return err
+		}
 		(*s)[name], err = signSelf(c)
 		return err
 	}
 
-	if maxDepth == 0 {
-		return errors.New("failed to find root cert (chain too long)")
-	}
-
 	parent, ok := (*s)[c.parentCert]
 	if !ok {
-		return fmt.Errorf("failed to find cert named %s", name)
+		return fmt.Errorf("failed to find cert named %s", c.parentCert)
 	}
 
 	if parent.certDER == nil {
@@ -110,14 +223,50 @@ func (s *Store) signCertAndAncestors(name string, maxDepth int) error {
 		parent = (*s)[c.parentCert]
 	}
 
+	err = s.assignSerial(&c, parent, maxDepth)
+	if err != nil {
+		return err
+	}
 	(*s)[name], err = sign(c, parent)
 	return err
 }
 
+func (s *Store) assignSerial(c *KeyAndCert, issuer KeyAndCert, maxDepth int) error {
+	if c.cfg.SameSerialAs != "" {
+		if c.cfg.SameSerialAs == c.name {
+			return fmt.Errorf("%s: cannot reuse its own serial number", c.name)
+		}
+		other, ok := (*s)[c.cfg.SameSerialAs]
+		if !ok {
+			return fmt.Errorf("failed to find cert named %s", c.cfg.SameSerialAs)
+		}
+		// Serials only collide within an issuer, and a root is its own issuer
+		if c.parentCert == "" || other.parentCert != c.parentCert {
+			return fmt.Errorf("%s: can only reuse the serial number of a cert with the same issuer", c.name)
+		}
+		err := s.signCertAndAncestors(c.cfg.SameSerialAs, maxDepth-1)
+		if err != nil {
+			return err
+		}
+		c.template.SerialNumber = (*s)[c.cfg.SameSerialAs].certificate.SerialNumber
+	} else if c.cfg.SerialNumber == nil {
+		c.template.SerialNumber = issuer.serials.nextSerial()
+	}
+
+	err := issuer.serials.record(c.template.SerialNumber, c.name, c.cfg.SameSerialAs != "")
+	if err != nil {
+		return fmt.Errorf("%s: %w", issuer.name, err)
+	}
+	return nil
+}
+
 func signSelf(c KeyAndCert) (KeyAndCert, error) {
-	var err error
+	if c.privateKey == nil {
+		return c, fmt.Errorf("%s: cannot self-sign without a private key", c.name)
+	}
 
-	c.certDER, err = x509.CreateCertificate(rand.Reader, c.template, c.template, c.privateKey.Public(), c.privateKey)
+	var err error
+	c.certDER, err = createCertificate(c.template, c.template, c.publicKey, c.privateKey)
 	if err != nil {
 		return c, err
 	}
@@ -131,15 +280,15 @@ func signSelf(c KeyAndCert) (KeyAndCert, error) {
 func sign(c, parent KeyAndCert) (KeyAndCert, error) {
 	var err error
 
-	c.certChainDER = append(parent.certChainDER, parent.certDER)
+	// Issuer first, root last, as in a TLS Certificate message
+	c.certChainDER = append([][]byte{parent.certDER}, parent.certChainDER...)
 
 	// Trick Go into preserving the overridden AKI, if provided
 	savedParentSKI := parent.certificate.SubjectKeyId
 	if len(c.template.AuthorityKeyId) > 0 {
 		parent.certificate.SubjectKeyId = c.template.AuthorityKeyId
 	}
-	c.certDER, err = x509.CreateCertificate(rand.Reader, c.template, parent.certificate, c.privateKey.Public(),
-		parent.privateKey)
+	c.certDER, err = createCertificate(c.template, parent.certificate, c.publicKey, parent.privateKey)
 	if len(c.template.AuthorityKeyId) > 0 {
 		parent.certificate.SubjectKeyId = savedParentSKI
 	}
@@ -153,6 +302,27 @@ func sign(c, parent KeyAndCert) (KeyAndCert, error) {
 	return c, nil
 }
 
+func createCertificate(tmpl, parent *x509.Certificate, pub crypto.PublicKey, priv crypto.Signer) ([]byte, error) {
+	serial := tmpl.SerialNumber
+	if serial.Sign() >= 0 {
+		return x509.CreateCertificate(rand.Reader, tmpl, parent, pub, priv)
+	}
+
+	tmpl.SerialNumber = big.NewInt(0).Neg(serial)
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, priv)
+	tmpl.SerialNumber = serial
+	if err != nil {
+		return nil, err
+	}
+
+	crt, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return replaceSerialNumber(der, serial, crt.SignatureAlgorithm, priv)
+}
+
 func marshalPublicKey(pk any) (publicKeyBytes []byte, err error) {
 	switch pub := pk.(type) {
 	case *rsa.PublicKey:
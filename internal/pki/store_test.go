@@ -0,0 +1,24 @@
+package pki
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"tls-tools/internal/config"
+)
+
+func TestKeyAndCert_GetCertChainDER(t *testing.T) {
+	store, err := NewStoreFromConfig(map[string]config.Cert{
+		"root":         {KeyType: "P-256", Purpose: "root-ca"},
+		"intermediate": {KeyType: "P-256", Purpose: "intermediate-ca", Parent: "root"},
+		"leaf":         {KeyType: "P-256", Parent: "intermediate"},
+	})
+	assert.Nil(t, err)
+
+	root, intermediate, leaf := store["root"].GetCertDER(), store["intermediate"].GetCertDER(), store["leaf"].GetCertDER()
+	assert.Equal(t, [][]byte{leaf, intermediate, root}, store["leaf"].GetCertChainDER())
+	assert.Equal(t, [][]byte{intermediate}, store["leaf"].GetIntermediatesDER())
+	assert.Equal(t, [][]byte{root}, store["root"].GetCertChainDER())
+	assert.Empty(t, store["root"].GetIntermediatesDER())
+}
@@ -0,0 +1,136 @@
+package pki
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+type attributeTypeAndValue struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// SubjectHash computes OpenSSL's hash of a DER-encoded name (X509_NAME_hash), as used for the file names in a
+// -CApath directory: the first four bytes, little-endian, of the SHA-1 of the name's canonical encoding.
+func SubjectHash(rawName []byte) (uint32, error) {
+	canon, err := canonicalName(rawName)
+	if err != nil {
+		return 0, err
+	}
+	sum := sha1.Sum(canon)
+	return binary.LittleEndian.Uint32(sum[:4]), nil
+}
+
+// canonicalName is OpenSSL's canonical encoding of a name: its RDNs without the enclosing sequence, with string values
+// converted to lowercase UTF8Strings and their whitespace trimmed and collapsed.
+func canonicalName(rawName []byte) ([]byte, error) {
+	var rdns []asn1.RawValue
+	rest, err := asn1.Unmarshal(rawName, &rdns)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, errors.New("trailing data after name")
+	}
+
+	var canon []byte
+	for _, rdn := range rdns {
+		var avas [][]byte
+		for b := rdn.Bytes; len(b) > 0; {
+			var ava attributeTypeAndValue
+			b, err = asn1.Unmarshal(b, &ava)
+			if err != nil {
+				return nil, err
+			}
+			if s, ok := decodeNameString(ava.Value); ok {
+				ava.Value = asn1.RawValue{Tag: asn1.TagUTF8String, Bytes: []byte(canonicalString(s))}
+			}
+			der, err := asn1.Marshal(ava)
+			if err != nil {
+				return nil, err
+			}
+			avas = append(avas, der)
+		}
+
+		sort.Slice(avas, func(i, j int) bool { return bytes.Compare(avas[i], avas[j]) < 0 })
+		set, err := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(avas, nil)})
+		if err != nil {
+			return nil, err
+		}
+		canon = append(canon, set...)
+	}
+	return canon, nil
+}
+
+// decodeNameString returns the value of the string types that OpenSSL canonicalizes.
+func decodeNameString(v asn1.RawValue) (string, bool) {
+	if v.Class != asn1.ClassUniversal {
+		return "", false
+	}
+
+	switch v.Tag {
+	case asn1.TagUTF8String:
+		return string(v.Bytes), true
+	case asn1.TagPrintableString, asn1.TagT61String, asn1.TagIA5String, 26: // 26 is VisibleString
+		// one byte per character
+		var b strings.Builder
+		for _, c := range v.Bytes {
+			b.WriteRune(rune(c))
+		}
+		return b.String(), true
+	case asn1.TagBMPString:
+		u := make([]uint16, len(v.Bytes)/2)
+		for i := range u {
+			u[i] = binary.BigEndian.Uint16(v.Bytes[2*i:])
+		}
+		return string(utf16.Decode(u)), true
+	case 28: // UniversalString
+		var b strings.Builder
+		for i := 0; i+4 <= len(v.Bytes); i += 4 {
+			b.WriteRune(rune(binary.BigEndian.Uint32(v.Bytes[i:])))
+		}
+		return b.String(), true
+	}
+	return "", false
+}
+
+// canonicalString trims and collapses ASCII whitespace and lowercases ASCII letters, leaving everything else alone.
+func canonicalString(s string) string {
+	isSpace := func(c byte) bool {
+		return c == ' ' || c == '\t' || c == '\n' || c == '\v' || c == '\f' || c == '\r'
+	}
+
+	b := []byte(s)
+	for len(b) > 0 && isSpace(b[0]) {
+		b = b[1:]
+	}
+	for len(b) > 0 && isSpace(b[len(b)-1]) {
+		b = b[:len(b)-1]
+	}
+
+	out := make([]byte, 0, len(b))
+	for i := 0; i < len(b); i++ {
+		c := b[i]
+		switch {
+		case c >= utf8.RuneSelf:
+			out = append(out, c)
+		case isSpace(c):
+			out = append(out, ' ')
+			for i+1 < len(b) && isSpace(b[i+1]) {
+				i++
+			}
+		case 'A' <= c && c <= 'Z':
+			out = append(out, c+'a'-'A')
+		default:
+			out = append(out, c)
+		}
+	}
+	return string(out)
+}
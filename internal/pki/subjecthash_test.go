@@ -0,0 +1,34 @@
+package pki
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSubjectHash(t *testing.T) {
+	// the expected hashes are from openssl x509 -hash
+	for expected, name := range map[uint32]pkix.RDNSequence{
+		0xec6a071e: {
+			{{Type: asn1.ObjectIdentifier{2, 5, 4, 6}, Value: "US"}},
+			{{Type: asn1.ObjectIdentifier{2, 5, 4, 10}, Value: "  Acme   Widgets  Inc "}},
+			{
+				{Type: asn1.ObjectIdentifier{2, 5, 4, 11}, Value: "Test"},
+				{Type: asn1.ObjectIdentifier{2, 5, 4, 3}, Value: "Root CA  One"},
+			},
+			{{Type: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 1}, Value: "Admin@Example.COM"}},
+		},
+		0x7cafd643: {
+			{{Type: asn1.ObjectIdentifier{2, 5, 4, 3}, Value: "Ünïcode Çà  X"}},
+			{{Type: asn1.ObjectIdentifier{2, 5, 4, 10}, Value: "Grüße"}},
+		},
+	} {
+		der, err := asn1.Marshal(name)
+		assert.Nil(t, err)
+		h, err := SubjectHash(der)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, h, name.String())
+	}
+}
@@ -0,0 +1,168 @@
+package pkihttp
+
+import (
+	"bytes"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ocsp"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/pki"
+)
+
+const (
+	ocspPath = "/ocsp"
+
+	contentTypeOCSPResponse = "application/ocsp-response"
+
+	maxOCSPRequestSize = 64 * 1024
+)
+
+var oidOCSPNonce = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}
+
+// ocspRequest is just enough of an OCSPRequest to get at the extensions, which ocsp.ParseRequest ignores.
+type ocspRequest struct {
+	TBSRequest struct {
+		Version       int           `asn1:"explicit,tag:0,default:0,optional"`
+		RequestorName asn1.RawValue `asn1:"explicit,tag:1,optional"`
+		RequestList   []asn1.RawValue
+		Extensions    []pkix.Extension `asn1:"explicit,tag:2,optional"`
+	}
+}
+
+func validateOCSPResponder(cfg config.OCSPResponder, store pki.Store) error {
+	var names []string
+	for ca, delegate := range cfg.Delegates {
+		names = append(names, ca, delegate)
+	}
+	for name, r := range cfg.Responses {
+		if name != "*" {
+			names = append(names, name)
+		}
+		if r.Responder != "" {
+			names = append(names, r.Responder)
+		}
+		if r.Signer != "" {
+			names = append(names, r.Signer)
+		}
+	}
+
+	for _, name := range names {
+		if _, ok := store[name]; !ok {
+			return fmt.Errorf("certificate not found: %s", name)
+		}
+	}
+	return nil
+}
+
+func (s *Server) serveOCSP(w http.ResponseWriter, r *http.Request) {
+	var der []byte
+	switch {
+	case r.Method == http.MethodPost:
+		var err error
+		der, err = io.ReadAll(io.LimitReader(r.Body, maxOCSPRequestSize))
+		if err != nil {
+			log.Printf("OCSP %s %s: %v", r.Method, r.URL.Path, err)
+			return
+		}
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, ocspPath+"/"):
+		var err error
+		der, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(r.URL.Path, ocspPath+"/"))
+		if err != nil {
+			log.Printf("OCSP %s %s: %v", r.Method, r.URL.Path, err)
+			writeOCSPResponse(w, ocsp.MalformedRequestErrorResponse)
+			return
+		}
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	req, err := ocsp.ParseRequest(der)
+	if err != nil {
+		log.Printf("OCSP %s %s: %v", r.Method, r.URL.Path, err)
+		writeOCSPResponse(w, ocsp.MalformedRequestErrorResponse)
+		return
+	}
+
+	caName, ok := s.findIssuer(req)
+	if !ok {
+		log.Printf("OCSP %s %s: serial %x: unknown issuer", r.Method, r.URL.Path, req.SerialNumber)
+		writeOCSPResponse(w, ocsp.UnauthorizedErrorResponse)
+		return
+	}
+
+	name := ""
+	if names := s.store[caName].GetIssuedSerials()[req.SerialNumber.Text(16)]; len(names) > 0 {
+		name = names[0]
+	}
+
+	opts, ok := s.ocsp.Responses[name]
+	if !ok {
+		opts = s.ocsp.Responses["*"]
+	}
+	if opts.Responder == "" {
+		opts.Responder = s.ocsp.Delegates[caName]
+	}
+
+	resp, err := s.store.NewOCSPResponse(caName, req, requestNonce(der), opts)
+	if err != nil {
+		log.Printf("OCSP %s %s: %v", r.Method, r.URL.Path, err)
+		writeOCSPResponse(w, ocsp.InternalErrorErrorResponse)
+		return
+	}
+
+	log.Printf("OCSP %s %s: serial %x (%s) from %s", r.Method, r.URL.Path, req.SerialNumber, name, caName)
+	writeOCSPResponse(w, resp)
+}
+
+func (s *Server) findIssuer(req *ocsp.Request) (string, bool) {
+	names := make([]string, 0, len(s.store))
+	for name := range s.store {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		crt := s.store[name].GetCertificate()
+		if !crt.IsCA {
+			continue
+		}
+		nameHash, keyHash, err := pki.IssuerHashes(crt, req.HashAlgorithm)
+		if err != nil {
+			continue
+		}
+		if bytes.Equal(nameHash, req.IssuerNameHash) && bytes.Equal(keyHash, req.IssuerKeyHash) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// requestNonce returns the value of the request's nonce extension, if there is one.
+func requestNonce(der []byte) []byte {
+	var req ocspRequest
+	_, err := asn1.Unmarshal(der, &req)
+	if err != nil {
+		return nil
+	}
+	for _, ext := range req.TBSRequest.Extensions {
+		if ext.Id.Equal(oidOCSPNonce) {
+			return ext.Value
+		}
+	}
+	return nil
+}
+
+func writeOCSPResponse(w http.ResponseWriter, der []byte) {
+	w.Header().Set("Content-Type", contentTypeOCSPResponse)
+	_, _ = w.Write(der)
+}
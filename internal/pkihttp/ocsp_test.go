@@ -0,0 +1,59 @@
+package pkihttp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ocsp"
+
+	"tls-tools/internal/config"
+)
+
+func TestServer_ocsp(t *testing.T) {
+	store := newTestStore(t)
+	s, err := NewServerFromConfig("", config.HTTPServer{OCSP: &config.OCSPResponder{
+		Delegates: map[string]string{"Root CA": "Intermediate CA"},
+		Responses: map[string]config.OCSPResponse{"leaf": {Status: "revoked"}},
+	}}, store)
+	assert.Nil(t, err)
+
+	leaf := store["leaf"].GetCertificate()
+	issuer := store["Intermediate CA"].GetCertificate()
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	assert.Nil(t, err)
+
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/ocsp", bytes.NewReader(req)))
+	assert.Equal(t, contentTypeOCSPResponse, w.Result().Header.Get("Content-Type"))
+	resp, err := ocsp.ParseResponseForCert(w.Body.Bytes(), leaf, issuer)
+	assert.Nil(t, err)
+	assert.Equal(t, ocsp.Revoked, resp.Status)
+
+	// The intermediate's status comes from a delegated responder
+	root := store["Root CA"].GetCertificate()
+	req, err = ocsp.CreateRequest(issuer, root, nil)
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ocsp/"+base64.StdEncoding.EncodeToString(req), nil))
+	resp, err = ocsp.ParseResponseForCert(w.Body.Bytes(), issuer, root)
+	assert.Nil(t, err)
+	assert.Equal(t, ocsp.Good, resp.Status)
+	assert.Equal(t, store["Intermediate CA"].GetCertDER(), resp.Certificate.Raw)
+
+	// Nobody in the store issued this one
+	req, err = ocsp.CreateRequest(root, leaf, nil)
+	assert.Nil(t, err)
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/ocsp", bytes.NewReader(req)))
+	assert.Equal(t, ocsp.UnauthorizedErrorResponse, w.Body.Bytes())
+
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/ocsp", bytes.NewReader([]byte("garbage"))))
+	assert.Equal(t, ocsp.MalformedRequestErrorResponse, w.Body.Bytes())
+}
@@ -0,0 +1,248 @@
+package pkihttp
+
+import (
+	"context"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/keystore"
+	"tls-tools/internal/pki"
+)
+
+const (
+	crlPath  = "/crl/"
+	certPath = "/certs/"
+
+	contentTypeCRL   = "application/pkix-crl"
+	contentTypeCert  = "application/pkix-cert"
+	contentTypePKCS7 = "application/pkcs7-mime"
+)
+
+func NewServersFromConfig(cfg map[string]config.HTTPServer, store pki.Store) ([]*Server, error) {
+	servers := make([]*Server, 0, len(cfg))
+	for addr, sc := range cfg {
+		s, err := NewServerFromConfig(addr, sc, store)
+		if err != nil {
+			return nil, err
+		}
+		servers = append(servers, s)
+	}
+	return servers, nil
+}
+
+func NewServerFromConfig(addr string, cfg config.HTTPServer, store pki.Store) (*Server, error) {
+	s := Server{
+		Addr:      addr,
+		store:     store,
+		ocsp:      cfg.OCSP,
+		resources: make(map[string]resource),
+		faults:    make(map[string]fault),
+	}
+
+	if cfg.OCSP != nil {
+		err := validateOCSPResponder(*cfg.OCSP, store)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", addr, err)
+		}
+	}
+
+	for name, f := range cfg.Faults {
+		if _, ok := store[name]; !ok && name != "*" {
+			return nil, fmt.Errorf("%s: certificate not found: %s", addr, name)
+		}
+		ff := fault{HTTPFault: f}
+		if f.Delay != "" {
+			var err error
+			ff.delay, err = time.ParseDuration(f.Delay)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid delay: %w", addr, err)
+			}
+		}
+		s.faults[name] = ff
+	}
+
+	// CAs whose names slugify the same way would overwrite each other's URLs, and an empty slug makes no URL at all
+	slugs := make(map[string]string)
+	for name, kac := range store {
+		if !kac.GetCertificate().IsCA {
+			continue
+		}
+		slug := pki.Slug(name)
+		if slug == "" {
+			return nil, fmt.Errorf("%s: cert %q has no ASCII letters or digits to make a URL from", addr, name)
+		}
+		if other, ok := slugs[slug]; ok {
+			if other > name {
+				name, other = other, name
+			}
+			return nil, fmt.Errorf("%s: certs %q and %q would both be served as %q", addr, other, name, slug)
+		}
+		slugs[slug] = name
+	}
+
+	for name, kac := range store {
+		if !kac.GetCertificate().IsCA {
+			continue
+		}
+
+		s.resources[certPath+pki.Slug(name)+".crt"] = resource{
+			name:        name,
+			der:         kac.GetCertDER(),
+			pemType:     "CERTIFICATE",
+			contentType: contentTypeCert,
+		}
+
+		// AIA caIssuers URLs may also point at a certs-only PKCS#7 bundle of the certs issued to the CA
+		p7, err := keystore.NewPKCS7([][]byte{kac.GetCertDER()})
+		if err != nil {
+			return nil, err
+		}
+		s.resources[certPath+pki.Slug(name)+".p7c"] = resource{
+			name:        name,
+			der:         p7,
+			pemType:     "PKCS7",
+			contentType: contentTypePKCS7,
+		}
+
+		if kac.GetCRLDER() == nil {
+			continue
+		}
+
+		// A stale CRL is an otherwise identical one whose validity period ended a week ago
+		opts := config.CRLOptions{}
+		if kac.GetConfig().CRL != nil {
+			opts = *kac.GetConfig().CRL
+		}
+		opts.ThisUpdate = "-336h"
+		opts.NextUpdate = "-168h"
+		stale, err := store.NewCRL(name, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		s.resources[crlPath+pki.Slug(name)+".crl"] = resource{
+			name:        name,
+			der:         kac.GetCRLDER(),
+			staleDER:    stale,
+			pemType:     "X509 CRL",
+			contentType: contentTypeCRL,
+		}
+	}
+
+	return &s, nil
+}
+
+// Server serves CRLs and CA certs over plain HTTP, as is usual for CRL distribution points and AIA URLs, and can
+// answer OCSP requests too.
+type Server struct {
+	Addr      string
+	store     pki.Store
+	ocsp      *config.OCSPResponder
+	resources map[string]resource
+	faults    map[string]fault
+}
+
+type resource struct {
+	name        string
+	der         []byte
+	staleDER    []byte
+	pemType     string
+	contentType string
+}
+
+type fault struct {
+	config.HTTPFault
+	delay time.Duration
+}
+
+func (s *Server) Start(ctx context.Context) {
+	l, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		log.Println(fmt.Errorf("net.Listen: %w", err))
+		return
+	}
+
+	paths := make([]string, 0, len(s.resources))
+	for p := range s.resources {
+		paths = append(paths, p)
+	}
+	sort.Strings(paths)
+	for _, p := range paths {
+		log.Printf("Serving %s at http://%s%s", s.resources[p].name, l.Addr(), p)
+	}
+	if s.ocsp != nil {
+		log.Printf("Answering OCSP requests at http://%s%s", l.Addr(), ocspPath)
+	}
+
+	hs := &http.Server{Handler: s}
+	go func() {
+		err := hs.Serve(l)
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Println(fmt.Errorf("hs.Serve: %w", err))
+		}
+	}()
+
+	<-ctx.Done()
+	err = hs.Close()
+	if err != nil {
+		log.Println(fmt.Errorf("hs.Close: %w", err))
+	}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if s.ocsp != nil && (r.URL.Path == ocspPath || strings.HasPrefix(r.URL.Path, ocspPath+"/")) {
+		s.serveOCSP(w, r)
+		return
+	}
+
+	res, ok := s.resources[r.URL.Path]
+	if !ok || (r.Method != http.MethodGet && r.Method != http.MethodHead) {
+		log.Printf("HTTP %s %s: not found", r.Method, r.URL.Path)
+		http.NotFound(w, r)
+		return
+	}
+
+	f, ok := s.faults[res.name]
+	if !ok {
+		f = s.faults["*"]
+	}
+
+	if f.delay > 0 {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-time.After(f.delay):
+		}
+	}
+
+	if f.Status != 0 {
+		log.Printf("HTTP %s %s: %d (on purpose)", r.Method, r.URL.Path, f.Status)
+		w.WriteHeader(f.Status)
+		return
+	}
+
+	body := res.der
+	if f.Stale && res.staleDER != nil {
+		body = res.staleDER
+	}
+	if f.PEM {
+		body = pem.EncodeToMemory(&pem.Block{Type: res.pemType, Bytes: body})
+	}
+
+	contentType := res.contentType
+	if f.ContentType != "" {
+		contentType = f.ContentType
+	}
+
+	log.Printf("HTTP %s %s: %s", r.Method, r.URL.Path, res.name)
+	w.Header().Set("Content-Type", contentType)
+	_, _ = w.Write(body)
+}
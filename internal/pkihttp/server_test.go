@@ -0,0 +1,100 @@
+package pkihttp
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/pki"
+)
+
+func TestServer(t *testing.T) {
+	store := newTestStore(t)
+	s, err := NewServerFromConfig("", config.HTTPServer{}, store)
+	assert.Nil(t, err)
+
+	resp, body := get(t, s, "/crl/root-ca.crl")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, contentTypeCRL, resp.Header.Get("Content-Type"))
+	crl, err := x509.ParseRevocationList(body)
+	assert.Nil(t, err)
+	assert.True(t, crl.NextUpdate.After(time.Now()))
+
+	resp, body = get(t, s, "/certs/root-ca.crt")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, contentTypeCert, resp.Header.Get("Content-Type"))
+	assert.Equal(t, store["Root CA"].GetCertDER(), body)
+
+	resp, _ = get(t, s, "/certs/root-ca.p7c")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, contentTypePKCS7, resp.Header.Get("Content-Type"))
+
+	resp, _ = get(t, s, "/certs/leaf.crt")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestServer_slugCollision(t *testing.T) {
+	store, err := pki.NewStoreFromConfig(map[string]config.Cert{
+		"Root CA": {KeyType: "P-256", Purpose: "root-ca"},
+		"root-ca": {KeyType: "P-256", Purpose: "root-ca"},
+	})
+	assert.Nil(t, err)
+
+	_, err = NewServerFromConfig("", config.HTTPServer{}, store)
+	assert.ErrorContains(t, err, `"Root CA" and "root-ca"`)
+
+	store, err = pki.NewStoreFromConfig(map[string]config.Cert{"根": {KeyType: "P-256", Purpose: "root-ca"}})
+	assert.Nil(t, err)
+	_, err = NewServerFromConfig("", config.HTTPServer{}, store)
+	assert.ErrorContains(t, err, `"根"`)
+}
+
+func TestServer_faults(t *testing.T) {
+	store := newTestStore(t)
+	s, err := NewServerFromConfig("", config.HTTPServer{Faults: map[string]config.HTTPFault{
+		"Root CA": {PEM: true, Stale: true, ContentType: "text/plain"},
+		"*":       {Status: http.StatusNotFound},
+	}}, store)
+	assert.Nil(t, err)
+
+	resp, body := get(t, s, "/crl/root-ca.crl")
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "text/plain", resp.Header.Get("Content-Type"))
+	block, _ := pem.Decode(body)
+	assert.NotNil(t, block)
+	crl, err := x509.ParseRevocationList(block.Bytes)
+	assert.Nil(t, err)
+	assert.True(t, crl.NextUpdate.Before(time.Now()))
+
+	resp, _ = get(t, s, "/crl/intermediate-ca.crl")
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+
+	_, err = NewServerFromConfig("", config.HTTPServer{Faults: map[string]config.HTTPFault{"bogus": {}}}, store)
+	assert.NotNil(t, err)
+}
+
+func newTestStore(t *testing.T) pki.Store {
+	store, err := pki.NewStoreFromConfig(map[string]config.Cert{
+		"Root CA":         {KeyType: "P-256", Purpose: "root-ca"},
+		"Intermediate CA": {KeyType: "P-256", Purpose: "intermediate-ca", Parent: "Root CA"},
+		"leaf":            {KeyType: "P-256", Parent: "Intermediate CA"},
+	})
+	assert.Nil(t, err)
+	return store
+}
+
+func get(t *testing.T, s *Server, path string) (*http.Response, []byte) {
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	resp := w.Result()
+	body, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	return resp, body
+}
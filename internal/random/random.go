@@ -18,6 +18,12 @@ func Integer(max int) int {
 	return int(n.Int64())
 }
 
+// Chance returns true with probability p.
+func Chance(p float64) bool {
+	const precision = 1000000
+	return Integer(precision) < int(p*precision)
+}
+
 func Name(maxLen int) string {
 	l := Integer(maxLen) + 1
 	b := make([]byte, (l*3+3)/4)
@@ -28,13 +34,17 @@ func Name(maxLen int) string {
 	return base64.RawURLEncoding.EncodeToString(b)[:l]
 }
 
-func SerialNumber() *big.Int {
-	sn := make([]byte, 18)
-	_, err := rand.Read(sn)
+func Bytes(n int) []byte {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
 	if err != nil {
 		panic(err)
 	}
-	return big.NewInt(0).SetBytes(sn)
+	return b
+}
+
+func SerialNumber() *big.Int {
+	return big.NewInt(0).SetBytes(Bytes(18))
 }
 
 func CountryCode() string {
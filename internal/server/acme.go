@@ -0,0 +1,303 @@
+package server
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/acme"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/pki"
+)
+
+const (
+	defaultACMEKeyType   = "P-256"
+	defaultACMEHTTPAddr  = ":80"
+	acmeRetryInterval    = time.Minute
+	acmeChallengeTimeout = 5 * time.Minute
+)
+
+// acmeCertSource obtains a cert from an ACME directory and renews it before it expires.
+type acmeCertSource struct {
+	cfg         config.ACMEClient
+	client      *acme.Client
+	renewBefore time.Duration
+
+	mu         sync.Mutex
+	cert       *tls.Certificate
+	challenges map[string]*tls.Certificate // tls-alpn-01 certs by domain
+	tokens     map[string]string           // http-01 responses by path
+}
+
+func newACMECertSource(cfg config.ACMEClient, store pki.Store) (*acmeCertSource, error) {
+	if cfg.Directory == "" {
+		return nil, errors.New("no ACME directory specified")
+	}
+	if len(cfg.Domains) == 0 {
+		return nil, errors.New("no ACME domains specified")
+	}
+
+	switch cfg.Challenge {
+	case "":
+		cfg.Challenge = "tls-alpn-01"
+	case "tls-alpn-01", "http-01":
+	default:
+		return nil, fmt.Errorf("unsupported ACME challenge type: %s", cfg.Challenge)
+	}
+
+	if cfg.HTTPAddr == "" {
+		cfg.HTTPAddr = defaultACMEHTTPAddr
+	}
+	if cfg.KeyType == "" {
+		cfg.KeyType = defaultACMEKeyType
+	}
+
+	var renewBefore time.Duration
+	if cfg.RenewBefore != "" {
+		var err error
+		renewBefore, err = time.ParseDuration(cfg.RenewBefore)
+		if err != nil {
+			return nil, fmt.Errorf("invalid renewBefore: %w", err)
+		}
+	}
+
+	// Trust the system roots plus every root in the store, so the directory can be served by a local test CA
+	roots, err := x509.SystemCertPool()
+	if err != nil {
+		roots = x509.NewCertPool()
+	}
+	for _, kac := range store {
+		if kac.IsRootCA() {
+			roots.AddCert(kac.GetCertificate())
+		}
+	}
+
+	accountKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return &acmeCertSource{
+		cfg:         cfg,
+		renewBefore: renewBefore,
+		client: &acme.Client{
+			Key:          accountKey,
+			DirectoryURL: cfg.Directory,
+			HTTPClient: &http.Client{
+				Transport: &http.Transport{
+					TLSClientConfig: &tls.Config{RootCAs: roots, InsecureSkipVerify: cfg.Insecure},
+				},
+			},
+		},
+		challenges: make(map[string]*tls.Certificate),
+		tokens:     make(map[string]string),
+	}, nil
+}
+
+// GetCertificate answers tls-alpn-01 challenges and otherwise returns the most recently issued cert, if any.
+func (a *acmeCertSource) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for _, p := range hello.SupportedProtos {
+		if p == acme.ALPNProto {
+			crt, ok := a.challenges[strings.ToLower(hello.ServerName)]
+			if !ok {
+				return nil, fmt.Errorf("no tls-alpn-01 challenge for %s", hello.ServerName)
+			}
+			return crt, nil
+		}
+	}
+
+	// Returning nil falls back to the listener's configured certs
+	return a.cert, nil
+}
+
+func (a *acmeCertSource) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	a.mu.Lock()
+	resp, ok := a.tokens[r.URL.Path]
+	a.mu.Unlock()
+
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	_, _ = w.Write([]byte(resp))
+}
+
+// run obtains a cert and then keeps renewing it until ctx is done.
+func (a *acmeCertSource) run(ctx context.Context) {
+	if a.cfg.Challenge == "http-01" {
+		hs := &http.Server{Addr: a.cfg.HTTPAddr, Handler: a}
+		go func() {
+			err := hs.ListenAndServe()
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				log.Println(fmt.Errorf("hs.ListenAndServe: %w", err))
+			}
+		}()
+		defer func() { _ = hs.Close() }()
+	}
+
+	for {
+		wait := acmeRetryInterval
+		leaf, err := a.obtain(ctx)
+		if err != nil {
+			log.Printf("ACME: failed to obtain a cert for %v: %v", a.cfg.Domains, err)
+		} else {
+			log.Printf("ACME: obtained a cert for %v, valid until %s", a.cfg.Domains, leaf.NotAfter.Format(time.RFC3339))
+			wait = a.renewalWait(leaf)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// renewalWait is how long to wait before renewing, but never less than the retry interval, so that a renewBefore as
+// long as the cert's lifetime doesn't place orders back to back.
+func (a *acmeCertSource) renewalWait(leaf *x509.Certificate) time.Duration {
+	wait := time.Until(a.renewalTime(leaf))
+	if wait < acmeRetryInterval {
+		wait = acmeRetryInterval
+	}
+	return wait
+}
+
+func (a *acmeCertSource) renewalTime(leaf *x509.Certificate) time.Time {
+	renewBefore := a.renewBefore
+	if renewBefore == 0 {
+		renewBefore = leaf.NotAfter.Sub(leaf.NotBefore) / 3
+	}
+	return leaf.NotAfter.Add(-renewBefore)
+}
+
+func (a *acmeCertSource) obtain(ctx context.Context) (*x509.Certificate, error) {
+	ctx, cancel := context.WithTimeout(ctx, acmeChallengeTimeout)
+	defer cancel()
+
+	acct := &acme.Account{}
+	if a.cfg.Email != "" {
+		acct.Contact = []string{"mailto:" + a.cfg.Email}
+	}
+	_, err := a.client.Register(ctx, acct, acme.AcceptTOS)
+	if err != nil && !errors.Is(err, acme.ErrAccountAlreadyExists) {
+		return nil, err
+	}
+
+	o, err := a.client.AuthorizeOrder(ctx, acme.DomainIDs(a.cfg.Domains...))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, u := range o.AuthzURLs {
+		err = a.authorize(ctx, u)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	o, err = a.client.WaitOrder(ctx, o.URI)
+	if err != nil {
+		return nil, err
+	}
+
+	priv, err := pki.NewKeypair(a.cfg.KeyType)
+	if err != nil {
+		return nil, err
+	}
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  pkix.Name{CommonName: a.cfg.Domains[0]},
+		DNSNames: a.cfg.Domains,
+	}, priv)
+	if err != nil {
+		return nil, err
+	}
+
+	chain, _, err := a.client.CreateOrderCert(ctx, o.FinalizeURL, csr, true)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(chain[0])
+	if err != nil {
+		return nil, err
+	}
+
+	a.mu.Lock()
+	a.cert = &tls.Certificate{Certificate: chain, PrivateKey: priv, Leaf: leaf}
+	a.mu.Unlock()
+
+	return leaf, nil
+}
+
+func (a *acmeCertSource) authorize(ctx context.Context, authzURL string) error {
+	z, err := a.client.GetAuthorization(ctx, authzURL)
+	if err != nil {
+		return err
+	}
+	if z.Status == acme.StatusValid {
+		return nil
+	}
+
+	var chal *acme.Challenge
+	for _, c := range z.Challenges {
+		if c.Type == a.cfg.Challenge {
+			chal = c
+		}
+	}
+	if chal == nil {
+		return fmt.Errorf("no %s challenge offered for %s", a.cfg.Challenge, z.Identifier.Value)
+	}
+
+	domain := strings.ToLower(z.Identifier.Value)
+	switch chal.Type {
+	case "tls-alpn-01":
+		crt, err := a.client.TLSALPN01ChallengeCert(chal.Token, domain)
+		if err != nil {
+			return err
+		}
+		a.mu.Lock()
+		a.challenges[domain] = &crt
+		a.mu.Unlock()
+		defer func() {
+			a.mu.Lock()
+			delete(a.challenges, domain)
+			a.mu.Unlock()
+		}()
+	case "http-01":
+		resp, err := a.client.HTTP01ChallengeResponse(chal.Token)
+		if err != nil {
+			return err
+		}
+		p := a.client.HTTP01ChallengePath(chal.Token)
+		a.mu.Lock()
+		a.tokens[p] = resp
+		a.mu.Unlock()
+		defer func() {
+			a.mu.Lock()
+			delete(a.tokens, p)
+			a.mu.Unlock()
+		}()
+	}
+
+	_, err = a.client.Accept(ctx, chal)
+	if err != nil {
+		return err
+	}
+	_, err = a.client.WaitAuthorization(ctx, authzURL)
+	return err
+}
@@ -0,0 +1,82 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	acmeserver "tls-tools/internal/acme"
+	"tls-tools/internal/config"
+	"tls-tools/internal/pki"
+)
+
+func TestServer_acmeCertSource(t *testing.T) {
+	store, err := pki.NewStoreFromConfig(map[string]config.Cert{
+		"ca":       {KeyType: "P-256", Purpose: "root-ca"},
+		"acme":     {KeyType: "P-256", Parent: "ca", IPAddresses: []string{"127.0.0.1"}},
+		"fallback": {KeyType: "P-256", DNSNames: []string{"fallback.example.com"}},
+	})
+	assert.Nil(t, err)
+
+	port := freePort(t)
+	as, err := acmeserver.NewServerFromConfig("", config.ACMEServer{Issuer: "ca", Cert: "acme", TLSPort: port}, store)
+	assert.Nil(t, err)
+	ts := httptest.NewUnstartedServer(as.Handler())
+	ts.TLS = as.TLSConfig
+	ts.StartTLS()
+	defer ts.Close()
+
+	addr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	srv, err := NewServerFromConfig(map[string]config.Listener{
+		addr: {
+			Certs: []string{"fallback"},
+			ACME:  &config.ACMEClient{Directory: ts.URL + "/dir", Domains: []string{"localhost"}},
+		},
+	}, store)
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go srv.Start(ctx)
+
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: "localhost", InsecureSkipVerify: true})
+		if err == nil {
+			leaf := conn.ConnectionState().PeerCertificates[0]
+			_ = conn.Close()
+			if len(leaf.DNSNames) == 1 && leaf.DNSNames[0] == "localhost" {
+				assert.Nil(t, leaf.CheckSignatureFrom(store["ca"].GetCertificate()))
+				return
+			}
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	t.Fatal("listener never served the ACME cert")
+}
+
+func TestACMECertSource_renewalWait(t *testing.T) {
+	now := time.Now()
+	leaf := &x509.Certificate{NotBefore: now.Add(-time.Hour), NotAfter: now.Add(2 * time.Hour)}
+
+	a := acmeCertSource{}
+	assert.InDelta(t, float64(time.Hour), float64(a.renewalWait(leaf)), float64(time.Second))
+
+	// renewing before the whole lifetime would mean renewing right away, again and again
+	a.renewBefore = 24 * time.Hour
+	assert.Equal(t, acmeRetryInterval, a.renewalWait(leaf))
+}
+
+func freePort(t *testing.T) int {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer func() { _ = l.Close() }()
+	return l.Addr().(*net.TCPAddr).Port
+}
@@ -0,0 +1,79 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+)
+
+// requireALPN wraps a listener's GetConfigForClient, if it has one, to turn away clients that won't agree on a
+// protocol. crypto/tls already aborts with a no_application_protocol alert when the client's protocols and the
+// config's don't overlap, but it lets through HTTP/1.1 clients of an h2 server, and clients that offer none. The first
+// get a config without h2, so crypto/tls turns them away itself; for the others, crypto/tls gives a config no say, so
+// they can only be failed with an internal_error alert.
+func requireALPN(tc *tls.Config) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	next := tc.GetConfigForClient
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		cfg := tc
+		if next != nil {
+			c, err := next(hello)
+			if err != nil {
+				return nil, err
+			}
+			if c != nil {
+				cfg = c
+			}
+		}
+
+		if len(hello.SupportedProtos) == 0 {
+			return nil, fmt.Errorf("no application protocol offered (SNI %q)", hello.ServerName)
+		}
+		if !offersAny(hello.SupportedProtos, cfg.NextProtos) && contains(cfg.NextProtos, "h2") &&
+			contains(hello.SupportedProtos, "http/1.1") {
+			cfg = cfg.Clone()
+			cfg.NextProtos = withoutFallback(cfg.NextProtos, hello.SupportedProtos)
+			return cfg, nil
+		}
+		if cfg == tc {
+			// crypto/tls takes nil to mean the listener's config
+			return nil, nil
+		}
+		return cfg, nil
+	}
+}
+
+// withoutFallback replaces h2 in a list of protocols none of which were offered, so that crypto/tls doesn't fall back
+// to HTTP/1.1 and can't skip ALPN for want of protocols.
+func withoutFallback(protos, offered []string) []string {
+	placeholder := "h2-only"
+	for contains(offered, placeholder) {
+		placeholder += "-"
+	}
+	out := make([]string, 0, len(protos))
+	for _, p := range protos {
+		if p == "h2" {
+			p = placeholder
+		}
+		if !contains(out, p) {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func offersAny(offered, accepted []string) bool {
+	for _, p := range offered {
+		if contains(accepted, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,66 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/pki"
+)
+
+func TestNewServerFromConfig_requireALPN(t *testing.T) {
+	store, err := pki.NewStoreFromConfig(map[string]config.Cert{
+		"ca":     {KeyType: "P-256", Purpose: "root-ca"},
+		"server": {KeyType: "P-256", Parent: "ca", DNSNames: []string{"server.test"}},
+	})
+	assert.Nil(t, err)
+
+	srv, err := NewServerFromConfig(map[string]config.Listener{
+		"h2": {Certs: []string{"server"}, ALPN: []string{"h2"}, RequireALPN: true},
+	}, store)
+	assert.Nil(t, err)
+	tc := srv.ListenerConfigs[0].TLSConf
+
+	for _, tt := range []struct {
+		protos []string
+		err    string
+	}{
+		{protos: []string{"h2", "http/1.1"}},
+		// crypto/tls doesn't let a config turn these away with no_application_protocol
+		{protos: nil, err: "internal error"},
+		{protos: []string{"http/1.1"}, err: "no application protocol"},
+		{protos: []string{"spdy/3"}, err: "no application protocol"},
+	} {
+		proto, err := alpnHandshake(tc, tt.protos)
+		if tt.err == "" {
+			assert.Nil(t, err)
+			assert.Equal(t, "h2", proto)
+		} else {
+			assert.ErrorContains(t, err, tt.err, tt.protos)
+		}
+	}
+
+	_, err = NewServerFromConfig(map[string]config.Listener{
+		"none": {Certs: []string{"server"}, RequireALPN: true},
+	}, store)
+	assert.NotNil(t, err)
+}
+
+// alpnHandshake connects to a server with the given config over a pipe, offering the given protocols.
+func alpnHandshake(serverConf *tls.Config, protos []string) (string, error) {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+
+	go func() {
+		_ = tls.Server(s, serverConf).Handshake()
+		_ = s.Close()
+	}()
+
+	tc := tls.Client(c, &tls.Config{ServerName: "server.test", InsecureSkipVerify: true, NextProtos: protos})
+	err := tc.Handshake()
+	return tc.ConnectionState().NegotiatedProtocol, err
+}
@@ -0,0 +1,185 @@
+package server
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/tlsutil"
+)
+
+// httpProtos are advertised over ALPN by the listeners that speak HTTP, preferring HTTP/2.
+var httpProtos = []string{"h2", "http/1.1"}
+
+// app is what a listener does with its connections once they're accepted.
+type app interface {
+	serve(l net.Listener) // returns once l is closed
+	speaksHTTP() bool
+}
+
+func newApp(l config.Listener) (app, error) {
+	switch strings.ToLower(strings.TrimSpace(l.App)) {
+	case "", "discard":
+		return discardApp{}, nil
+	case "echo":
+		return echoApp{}, nil
+	case "http":
+		return httpApp{handler: http.HandlerFunc(serveConnectionInfo)}, nil
+	case "static":
+		if l.StaticDir == "" {
+			return nil, errors.New("the static app needs a staticDir")
+		}
+		return httpApp{handler: http.FileServer(http.Dir(l.StaticDir))}, nil
+	default:
+		return nil, fmt.Errorf("invalid app: %s", l.App)
+	}
+}
+
+// advertiseHTTP sets a config's ALPN list to the HTTP protocols, unless it has one of its own.
+func advertiseHTTP(tc *tls.Config) {
+	if len(tc.NextProtos) == 0 {
+		tc.NextProtos = append(tc.NextProtos, httpProtos...)
+	}
+}
+
+// acceptLoop hands each connection to handle on its own goroutine until l is closed.
+func acceptLoop(l net.Listener, handle func(c *tls.Conn)) {
+	for {
+		c, err := l.Accept()
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return
+			}
+			log.Println(err)
+			continue
+		}
+		go func() {
+			defer func() {
+				err := c.Close()
+				if err != nil && !errors.Is(err, net.ErrClosed) {
+					log.Println(fmt.Errorf("c.Close: %w", err))
+				}
+			}()
+			handle(c.(*tls.Conn))
+		}()
+	}
+}
+
+// discardApp completes the handshake, waits a moment for anything the client sends, and hangs up.
+type discardApp struct{}
+
+func (discardApp) speaksHTTP() bool { return false }
+
+func (discardApp) serve(l net.Listener) {
+	acceptLoop(l, func(c *tls.Conn) {
+		err := c.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
+		if err != nil {
+			log.Println(fmt.Errorf("c.SetDeadline: %w", err))
+		}
+		// Reading nothing just completes the handshake
+		_, err = c.Read(nil)
+		if err != nil {
+			log.Printf("Handshake with %s failed: %v", c.RemoteAddr(), err)
+		}
+	})
+}
+
+// echoApp sends back whatever the client sends until it hangs up.
+type echoApp struct{}
+
+func (echoApp) speaksHTTP() bool { return false }
+
+func (echoApp) serve(l net.Listener) {
+	acceptLoop(l, func(c *tls.Conn) {
+		err := c.Handshake()
+		if err != nil {
+			log.Printf("Handshake with %s failed: %v", c.RemoteAddr(), err)
+			return
+		}
+		_, err = io.Copy(c, c)
+		if err != nil {
+			log.Printf("Echo to %s: %v", c.RemoteAddr(), err)
+		}
+	})
+}
+
+// httpApp serves HTTP/1.1, and HTTP/2 to clients that ask for it over ALPN.
+type httpApp struct {
+	handler http.Handler
+}
+
+func (httpApp) speaksHTTP() bool { return true }
+
+func (a httpApp) serve(l net.Listener) {
+	hs := http.Server{Handler: a.handler, ReadHeaderTimeout: 10 * time.Second}
+	err := hs.Serve(l)
+	if err != nil && !errors.Is(err, net.ErrClosed) {
+		log.Println(fmt.Errorf("hs.Serve: %w", err))
+	}
+}
+
+// ConnectionInfo is what the http app responds with.
+type ConnectionInfo struct {
+	Proto       string     `json:"proto"` // HTTP version
+	RemoteAddr  string     `json:"remoteAddr"`
+	TLSVersion  string     `json:"tlsVersion"`
+	CipherSuite string     `json:"cipherSuite"`
+	ServerName  string     `json:"serverName,omitempty"` // SNI
+	ALPN        string     `json:"alpn,omitempty"`       // negotiated protocol
+	DidResume   bool       `json:"didResume"`
+	ClientCerts []CertInfo `json:"clientCerts,omitempty"` // leaf first
+}
+
+type CertInfo struct {
+	Subject   string    `json:"subject"`
+	Issuer    string    `json:"issuer"`
+	Serial    string    `json:"serial"` // hex
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+	SHA256    string    `json:"sha256"` // fingerprint of the DER
+}
+
+func newCertInfo(crt *x509.Certificate) CertInfo {
+	sum := sha256.Sum256(crt.Raw)
+	return CertInfo{
+		Subject:   crt.Subject.String(),
+		Issuer:    crt.Issuer.String(),
+		Serial:    crt.SerialNumber.Text(16),
+		NotBefore: crt.NotBefore,
+		NotAfter:  crt.NotAfter,
+		SHA256:    hex.EncodeToString(sum[:]),
+	}
+}
+
+func serveConnectionInfo(w http.ResponseWriter, r *http.Request) {
+	info := ConnectionInfo{Proto: r.Proto, RemoteAddr: r.RemoteAddr}
+	if cs := r.TLS; cs != nil {
+		info.TLSVersion = tlsutil.TLSVersionString(cs.Version)
+		info.CipherSuite = tls.CipherSuiteName(cs.CipherSuite)
+		info.ServerName = cs.ServerName
+		info.ALPN = cs.NegotiatedProtocol
+		info.DidResume = cs.DidResume
+		for _, crt := range cs.PeerCertificates {
+			info.ClientCerts = append(info.ClientCerts, newCertInfo(crt))
+		}
+	}
+
+	b, err := json.MarshalIndent(info, "", "  ")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_, _ = w.Write(append(b, '\n'))
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/pki"
+)
+
+func TestNewServerFromConfig_apps(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, "hello.txt"), []byte("hello\n"), 0600))
+
+	store, err := pki.NewStoreFromConfig(map[string]config.Cert{
+		"ca":     {KeyType: "P-256", Purpose: "root-ca"},
+		"server": {KeyType: "P-256", Parent: "ca", DNSNames: []string{"server.test"}},
+		"alice":  {KeyType: "P-256", Parent: "ca", Purpose: "client", Subject: &config.Subject{CN: strPtr("alice")}},
+	})
+	assert.Nil(t, err)
+
+	srv, err := NewServerFromConfig(map[string]config.Listener{
+		"echo":   {Certs: []string{"server"}, App: "echo"},
+		"http":   {Certs: []string{"server"}, App: "http", ClientAuth: &config.ClientAuth{Mode: "verify-if-given"}},
+		"static": {Certs: []string{"server"}, App: "static", StaticDir: dir},
+	}, store)
+	assert.Nil(t, err)
+	addrs := map[string]string{}
+	for _, lc := range srv.ListenerConfigs {
+		l, err := tls.Listen("tcp", "127.0.0.1:0", lc.TLSConf)
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer l.Close()
+		go lc.app.serve(l)
+		addrs[lc.Addr] = l.Addr().String()
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(store["ca"].GetCertificate())
+	tc := &tls.Config{ServerName: "server.test", RootCAs: roots}
+
+	t.Run("echo", func(t *testing.T) {
+		c, err := tls.Dial("tcp", addrs["echo"], tc)
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer c.Close()
+		_, err = c.Write([]byte("ping"))
+		assert.Nil(t, err)
+		b := make([]byte, 4)
+		_, err = io.ReadFull(c, b)
+		assert.Nil(t, err)
+		assert.Equal(t, "ping", string(b))
+	})
+
+	t.Run("http", func(t *testing.T) {
+		ctc := tc.Clone()
+		ctc.Certificates = []tls.Certificate{{
+			Certificate: store["alice"].GetCertChainDER(),
+			PrivateKey:  store["alice"].GetPrivateKey(),
+		}}
+		for _, h2 := range []bool{false, true} {
+			hc := http.Client{Transport: &http.Transport{TLSClientConfig: ctc.Clone(), ForceAttemptHTTP2: h2}}
+			resp, err := hc.Get("https://" + addrs["http"] + "/")
+			if !assert.Nil(t, err) {
+				return
+			}
+			var info ConnectionInfo
+			assert.Nil(t, json.NewDecoder(resp.Body).Decode(&info))
+			_ = resp.Body.Close()
+			hc.CloseIdleConnections()
+
+			if h2 {
+				assert.Equal(t, "HTTP/2.0", info.Proto)
+				assert.Equal(t, "h2", info.ALPN)
+			} else {
+				assert.Equal(t, "HTTP/1.1", info.Proto)
+			}
+			assert.Equal(t, "TLS 1.3", info.TLSVersion)
+			assert.Equal(t, "server.test", info.ServerName)
+			if assert.NotEmpty(t, info.ClientCerts) {
+				assert.Equal(t, "CN=alice", info.ClientCerts[0].Subject)
+			}
+		}
+	})
+
+	t.Run("static", func(t *testing.T) {
+		hc := http.Client{Transport: &http.Transport{TLSClientConfig: tc.Clone()}}
+		defer hc.CloseIdleConnections()
+		resp, err := hc.Get("https://" + addrs["static"] + "/hello.txt")
+		if !assert.Nil(t, err) {
+			return
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		assert.Nil(t, err)
+		assert.Equal(t, "hello\n", string(b))
+	})
+
+	_, err = NewServerFromConfig(map[string]config.Listener{"bad": {Certs: []string{"server"}, App: "static"}}, store)
+	assert.NotNil(t, err)
+	_, err = NewServerFromConfig(map[string]config.Listener{"bad": {Certs: []string{"server"}, App: "gopher"}}, store)
+	assert.NotNil(t, err)
+}
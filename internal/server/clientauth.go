@@ -0,0 +1,190 @@
+package server
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"path"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/pki"
+)
+
+// clientVerifier checks client certs and logs the outcome. It does the chain verification that crypto/tls would do in
+// the verifying modes too, so that rejected certs get logged along with the reason.
+type clientVerifier struct {
+	verify   bool
+	roots    *x509.CertPool
+	ekus     []x509.ExtKeyUsage
+	subjects []string
+	checkCRL bool
+	store    pki.Store
+	crls     map[string]*x509.RevocationList // by the issuer's DER
+}
+
+// configureClientAuth sets up client auth on tc, which already has the mode from the config.
+func configureClientAuth(cfg config.ClientAuth, tc *tls.Config, store pki.Store) error {
+	v := clientVerifier{
+		roots:    x509.NewCertPool(),
+		subjects: cfg.Subjects,
+		checkCRL: cfg.CheckCRLs,
+		store:    store,
+		crls:     make(map[string]*x509.RevocationList),
+	}
+
+	var err error
+	v.ekus, err = cfg.GetExtKeyUsages()
+	if err != nil {
+		return err
+	}
+	for _, p := range v.subjects {
+		if _, err := path.Match(p, ""); err != nil {
+			return fmt.Errorf("invalid subject pattern: %s", p)
+		}
+	}
+
+	if len(cfg.CAs) == 0 {
+		for _, kac := range store {
+			if kac.IsRootCA() {
+				v.roots.AddCert(kac.GetCertificate())
+			}
+		}
+	}
+	for _, name := range cfg.CAs {
+		kac, ok := store[name]
+		if !ok {
+			return fmt.Errorf("certificate not found: %s", name)
+		}
+		v.roots.AddCert(kac.GetCertificate())
+	}
+
+	for _, kac := range store {
+		if der := kac.GetCRLDER(); der != nil {
+			crl, err := x509.ParseRevocationList(der)
+			if err != nil {
+				return err
+			}
+			v.crls[string(kac.GetCertDER())] = crl
+		}
+	}
+
+	switch tc.ClientAuth {
+	case tls.VerifyClientCertIfGiven:
+		tc.ClientAuth = tls.RequestClientCert
+		v.verify = true
+	case tls.RequireAndVerifyClientCert:
+		tc.ClientAuth = tls.RequireAnyClientCert
+		v.verify = true
+	}
+	// The CAs are sent in the CertificateRequest whatever the mode
+	tc.ClientCAs = v.roots
+	tc.VerifyConnection = v.VerifyConnection
+
+	return nil
+}
+
+func (v *clientVerifier) VerifyConnection(cs tls.ConnectionState) error {
+	if len(cs.PeerCertificates) == 0 {
+		return nil
+	}
+
+	leaf := cs.PeerCertificates[0]
+	err := v.check(cs.PeerCertificates)
+	if err != nil {
+		log.Printf("Rejected client cert %q (SNI %q): %v", leaf.Subject, cs.ServerName, err)
+		return err
+	}
+	log.Printf("Accepted client cert %q (SNI %q)", leaf.Subject, cs.ServerName)
+	return nil
+}
+
+func (v *clientVerifier) check(certs []*x509.Certificate) error {
+	leaf := certs[0]
+
+	var issuer *x509.Certificate
+	if v.verify {
+		opts := x509.VerifyOptions{
+			Roots:         v.roots,
+			Intermediates: x509.NewCertPool(),
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		if v.ekus != nil {
+			opts.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageAny}
+		}
+		for _, c := range certs[1:] {
+			opts.Intermediates.AddCert(c)
+		}
+		chains, err := leaf.Verify(opts)
+		if err != nil {
+			return err
+		}
+		issuer = chains[0][0]
+		if len(chains[0]) > 1 {
+			issuer = chains[0][1]
+		}
+	}
+
+	for _, eku := range v.ekus {
+		if !hasExtKeyUsage(leaf, eku) {
+			return fmt.Errorf("missing extended key usage %s", config.ExtKeyUsageName(eku))
+		}
+	}
+
+	if len(v.subjects) > 0 && !v.subjectAllowed(leaf) {
+		return errors.New("subject not allowed")
+	}
+
+	if v.checkCRL {
+		if issuer == nil {
+			issuer = v.findIssuer(leaf)
+		}
+		if issuer == nil {
+			return errors.New("issuer not found, so its CRL can't be checked")
+		}
+		crl, ok := v.crls[string(issuer.Raw)]
+		if !ok {
+			return fmt.Errorf("no CRL for issuer %q", issuer.Subject)
+		}
+		for _, rc := range crl.RevokedCertificates {
+			if rc.SerialNumber.Cmp(leaf.SerialNumber) == 0 {
+				return fmt.Errorf("revoked at %s", rc.RevocationTime)
+			}
+		}
+	}
+
+	return nil
+}
+
+func (v *clientVerifier) subjectAllowed(leaf *x509.Certificate) bool {
+	for _, p := range v.subjects {
+		for _, s := range []string{leaf.Subject.CommonName, leaf.Subject.String()} {
+			if ok, _ := path.Match(p, s); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// findIssuer looks for the issuer of an unverified cert in the store.
+func (v *clientVerifier) findIssuer(crt *x509.Certificate) *x509.Certificate {
+	for _, kac := range v.store {
+		c := kac.GetCertificate()
+		if c != nil && bytes.Equal(c.RawSubject, crt.RawIssuer) && crt.CheckSignatureFrom(c) == nil {
+			return c
+		}
+	}
+	return nil
+}
+
+func hasExtKeyUsage(crt *x509.Certificate, eku x509.ExtKeyUsage) bool {
+	for _, u := range crt.ExtKeyUsage {
+		if u == eku || u == x509.ExtKeyUsageAny {
+			return true
+		}
+	}
+	return false
+}
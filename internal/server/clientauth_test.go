@@ -0,0 +1,81 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/pki"
+)
+
+func TestNewServerFromConfig_clientAuth(t *testing.T) {
+	eku := "clientAuth,emailProtection"
+	store, err := pki.NewStoreFromConfig(map[string]config.Cert{
+		"ca":       {KeyType: "P-256", Purpose: "root-ca"},
+		"other-ca": {KeyType: "P-256", Purpose: "root-ca"},
+		"server":   {KeyType: "P-256", Parent: "ca", DNSNames: []string{"server.test"}},
+		"alice":    {KeyType: "P-256", Parent: "ca", Purpose: "client", Subject: &config.Subject{CN: strPtr("alice")}},
+		"bob":      {KeyType: "P-256", Parent: "ca", Purpose: "client", Subject: &config.Subject{CN: strPtr("bob")}, ExtKeyUsage: &eku},
+		"mallory":  {KeyType: "P-256", Parent: "other-ca", Purpose: "client", Subject: &config.Subject{CN: strPtr("mallory")}},
+		"revoked":  {KeyType: "P-256", Parent: "ca", Purpose: "client", Revoked: &config.Revocation{}},
+	})
+	assert.Nil(t, err)
+
+	srv, err := NewServerFromConfig(map[string]config.Listener{
+		"verify": {Certs: []string{"server"}, ClientAuth: &config.ClientAuth{Mode: "require-and-verify", CAs: []string{"ca"}, CheckCRLs: true}},
+		"checks": {Certs: []string{"server"}, ClientAuth: &config.ClientAuth{Mode: "request", Subjects: []string{"b*"}, ExtKeyUsages: &eku}},
+	}, store)
+	assert.Nil(t, err)
+	listeners := map[string]*tls.Config{}
+	for _, lc := range srv.ListenerConfigs {
+		listeners[lc.Addr] = lc.TLSConf
+	}
+	assert.Equal(t, tls.RequireAnyClientCert, listeners["verify"].ClientAuth)
+
+	for _, tt := range []struct {
+		listener, client string
+		ok               bool
+	}{
+		{"verify", "alice", true},
+		{"verify", "mallory", false},
+		{"verify", "revoked", false},
+		{"verify", "", false},
+		{"checks", "bob", true},
+		{"checks", "alice", false},
+		{"checks", "mallory", false},
+		{"checks", "", true},
+	} {
+		err := clientHandshake(listeners[tt.listener], store, tt.client)
+		assert.Equal(t, tt.ok, err == nil, "%s with %q: %v", tt.listener, tt.client, err)
+	}
+}
+
+// clientHandshake connects to a server with the given config over a pipe, presenting the named cert if there is one.
+// Client auth only fails on the server's side in TLS 1.3, so that's where the error comes from.
+func clientHandshake(serverConf *tls.Config, store pki.Store, name string) error {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+
+	go func() {
+		_ = tls.Client(c, &tls.Config{
+			InsecureSkipVerify: true,
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				if name == "" {
+					return &tls.Certificate{}, nil
+				}
+				return &tls.Certificate{Certificate: store[name].GetCertChainDER(), PrivateKey: store[name].GetPrivateKey()}, nil
+			},
+		}).Handshake()
+		_ = c.Close()
+	}()
+
+	return tls.Server(s, serverConf).Handshake()
+}
+
+func strPtr(s string) *string {
+	return &s
+}
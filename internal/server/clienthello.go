@@ -0,0 +1,150 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"log"
+	"net"
+	"time"
+
+	"tls-tools/internal/tlsutil"
+)
+
+// maxClientHello is as much as helloConn keeps while waiting for a ClientHello to finish.
+const maxClientHello = 64 * 1024
+
+// helloListener hands out connections that keep a copy of the ClientHello, as crypto/tls reads it, for fingerprinting.
+type helloListener struct {
+	net.Listener
+}
+
+func (l helloListener) Accept() (net.Conn, error) {
+	c, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &helloConn{Conn: c, recording: true}, nil
+}
+
+// helloConn records what's read from it until it has a whole ClientHello, which may span several records.
+type helloConn struct {
+	net.Conn
+	recording bool
+	records   []byte // TLS records read so far
+	hello     []byte // the ClientHello handshake message, once it's complete
+}
+
+func (c *helloConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	if c.recording && n > 0 {
+		c.records = append(c.records, b[:n]...)
+		c.parseRecords()
+	}
+	return n, err
+}
+
+func (c *helloConn) parseRecords() {
+	var msg []byte
+	rest := c.records
+	for len(rest) >= 5 {
+		// handshake records only
+		if rest[0] != 22 {
+			c.stopRecording()
+			return
+		}
+		n := int(rest[3])<<8 | int(rest[4])
+		if len(rest) < 5+n {
+			break
+		}
+		msg = append(msg, rest[5:5+n]...)
+		rest = rest[5+n:]
+
+		if len(msg) >= 4 {
+			msgLen := int(msg[1])<<16 | int(msg[2])<<8 | int(msg[3])
+			if len(msg) >= 4+msgLen {
+				c.hello = msg[:4+msgLen]
+				c.stopRecording()
+				return
+			}
+		}
+	}
+	if len(c.records) > maxClientHello {
+		c.stopRecording()
+	}
+}
+
+func (c *helloConn) stopRecording() {
+	c.recording = false
+	c.records = nil
+}
+
+// clientHelloEntry is the line logged for each connection.
+type clientHelloEntry struct {
+	Time             time.Time `json:"time"`
+	Listener         string    `json:"listener"`
+	RemoteAddr       string    `json:"remoteAddr"`
+	ServerName       string    `json:"serverName"`
+	Versions         []string  `json:"versions"`
+	CipherSuites     []string  `json:"cipherSuites"`
+	Curves           []string  `json:"curves"`
+	SignatureSchemes []string  `json:"signatureSchemes"`
+	ALPN             []string  `json:"alpn"`
+	JA3              string    `json:"ja3,omitempty"`
+	JA3Hash          string    `json:"ja3Hash,omitempty"`
+	JA4              string    `json:"ja4,omitempty"`
+	Error            string    `json:"error,omitempty"` // why there are no fingerprints
+}
+
+func newClientHelloEntry(addr string, hello *tls.ClientHelloInfo) clientHelloEntry {
+	e := clientHelloEntry{
+		Time:       time.Now().UTC(),
+		Listener:   addr,
+		ServerName: hello.ServerName,
+		ALPN:       hello.SupportedProtos,
+	}
+	if hello.Conn != nil {
+		e.RemoteAddr = hello.Conn.RemoteAddr().String()
+	}
+	for _, v := range hello.SupportedVersions {
+		e.Versions = append(e.Versions, tlsutil.TLSVersionString(v))
+	}
+	for _, cs := range hello.CipherSuites {
+		e.CipherSuites = append(e.CipherSuites, tls.CipherSuiteName(cs))
+	}
+	for _, c := range hello.SupportedCurves {
+		e.Curves = append(e.Curves, c.String())
+	}
+	for _, s := range hello.SignatureSchemes {
+		e.SignatureSchemes = append(e.SignatureSchemes, s.String())
+	}
+
+	hc, ok := hello.Conn.(*helloConn)
+	if !ok || hc.hello == nil {
+		e.Error = "ClientHello not recorded"
+		return e
+	}
+	raw, err := parseClientHello(hc.hello)
+	if err != nil {
+		e.Error = err.Error()
+		return e
+	}
+	e.JA3, e.JA3Hash = raw.ja3()
+	e.JA4 = raw.ja4()
+	return e
+}
+
+// logClientHellos wraps a listener's GetConfigForClient, if it has one, to log what each client offered.
+func logClientHellos(addr string, next func(*tls.ClientHelloInfo) (*tls.Config, error)) func(*tls.ClientHelloInfo) (*tls.Config, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		b, err := json.Marshal(newClientHelloEntry(addr, hello))
+		if err == nil {
+			log.Printf("ClientHello: %s", b)
+		} else {
+			log.Println(err)
+		}
+		if next == nil {
+			return nil, nil
+		}
+		return next(hello)
+	}
+}
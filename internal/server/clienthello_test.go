@@ -0,0 +1,120 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/hex"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogClientHellos(t *testing.T) {
+	c, s := net.Pipe()
+	defer c.Close()
+
+	var entry clientHelloEntry
+	serverConf := &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			entry = newClientHelloEntry("test", hello)
+			return nil, nil
+		},
+	}
+	go func() {
+		// there are no certs, so the handshake stops right after the ClientHello
+		_ = tls.Server(&helloConn{Conn: s, recording: true}, serverConf).Handshake()
+		_ = s.Close()
+	}()
+	_ = tls.Client(c, &tls.Config{
+		ServerName:   "server.test",
+		NextProtos:   []string{"h2", "http/1.1"},
+		MinVersion:   tls.VersionTLS12,
+		CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+	}).Handshake()
+
+	assert.Empty(t, entry.Error)
+	assert.Equal(t, "server.test", entry.ServerName)
+	assert.Equal(t, []string{"h2", "http/1.1"}, entry.ALPN)
+	assert.Equal(t, []string{"TLS 1.3", "TLS 1.2"}, entry.Versions)
+	assert.Contains(t, entry.CipherSuites, "TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256")
+
+	// the JA3 version is legacy_version; the ciphers are the configured one and the TLS 1.3 ones, in the client's order
+	fields := strings.Split(entry.JA3, ",")
+	if assert.Len(t, fields, 5) {
+		assert.Equal(t, "771", fields[0])
+		assert.ElementsMatch(t, []string{"4865", "4866", "4867", "49195"}, strings.Split(fields[1], "-"))
+	}
+	assert.Len(t, entry.JA3Hash, 32)
+	parts := strings.Split(entry.JA4, "_")
+	if assert.Len(t, parts, 3) {
+		assert.Equal(t, "t13d04", parts[0][:6])
+		assert.Equal(t, "h2", parts[0][8:])
+		assert.Equal(t, ja4Hash("1301,1302,1303,c02b"), parts[1])
+	}
+}
+
+func TestRawClientHello_ja4(t *testing.T) {
+	h := rawClientHello{
+		version:          0x0303,
+		cipherSuites:     []uint16{0x2a2a, 0x1302, 0x1301},
+		extensions:       []uint16{0x3a3a, extSupportedVersions, extServerName, extALPN, extSignatureAlgorithms},
+		signatureSchemes: []uint16{0x0804, 0x0403},
+		versions:         []uint16{0x4a4a, 0x0304, 0x0303},
+		alpn:             []string{"\x00\xff"},
+	}
+	assert.Equal(t, "t13d0204"+"0f_"+ja4Hash("1301,1302")+"_"+ja4Hash("000d,002b_0804,0403"), h.ja4())
+
+	s, _ := h.ja3()
+	assert.Equal(t, "771,4866-4865,43-0-16-13,,", s)
+
+	assert.Equal(t, "t00i0000"+"00_000000000000_000000000000", (&rawClientHello{}).ja4())
+}
+
+func TestParseClientHello_published(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		hello   string // handshake message, hex
+		ja3     string
+		ja3Hash string
+		ja4     string
+	}{
+		{
+			// the example in the JA3 README: TLS 1.0, SNI, three curves and uncompressed points
+			name: "ja3",
+			hello: "010000670301000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f000018002f0035000500" +
+				"0ac009c00ac013c01400320038001300040100002600000010000e00000b6578616d706c652e636f6d000a0008000600" +
+				"1700180019000b00020100",
+			ja3:     "769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,23-24-25,0",
+			ja3Hash: "ada70206e40642a3e4461f35503241d5",
+		},
+		{
+			// the Chrome example in the JA4 technical details, in Chrome's order and with GREASE values
+			name: "ja4",
+			hello: "010001130303000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f0000200a0a1301130213" +
+				"03c02bc02fc02cc030cca9cca8c013c014009c009d002f0035010000ca1a1a000000000010000e00000b6578616d706c" +
+				"652e636f6d00170000ff01000100000a000a00082a2a001d00170018000b00020100002300000010000e000c02683208" +
+				"687474702f312e31000500050100000000000d0012001004030804040105030805050108060601001200000033002600" +
+				"24001d00200000000000000000000000000000000000000000000000000000000000000000002d00020101002b000706" +
+				"3a3a03040303001b00030200024469000500030268320015000800000000000000002a2a000100",
+			ja4: "t13d1516h2_8daaf6152771_e5627efa2ab1",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := hex.DecodeString(tt.hello)
+			assert.Nil(t, err)
+			h, err := parseClientHello(msg)
+			if !assert.Nil(t, err) {
+				return
+			}
+			if tt.ja3 != "" {
+				s, sum := h.ja3()
+				assert.Equal(t, tt.ja3, s)
+				assert.Equal(t, tt.ja3Hash, sum)
+			}
+			if tt.ja4 != "" {
+				assert.Equal(t, tt.ja4, h.ja4())
+			}
+		})
+	}
+}
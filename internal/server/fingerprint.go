@@ -0,0 +1,270 @@
+package server
+
+import (
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/cryptobyte"
+)
+
+const (
+	extServerName          = 0
+	extSupportedGroups     = 10
+	extECPointFormats      = 11
+	extSignatureAlgorithms = 13
+	extALPN                = 16
+	extSupportedVersions   = 43
+)
+
+// rawClientHello is what the fingerprints need from a ClientHello, in the order the client sent it.
+type rawClientHello struct {
+	version          uint16 // legacy_version
+	cipherSuites     []uint16
+	extensions       []uint16
+	curves           []uint16
+	pointFormats     []uint8
+	signatureSchemes []uint16
+	versions         []uint16 // supported_versions
+	alpn             []string
+}
+
+// parseClientHello parses a ClientHello handshake message, header included.
+func parseClientHello(msg []byte) (*rawClientHello, error) {
+	s := cryptobyte.String(msg)
+	var msgType uint8
+	var body, sessionID, suites, compression cryptobyte.String
+	if !s.ReadUint8(&msgType) || msgType != 1 || !s.ReadUint24LengthPrefixed(&body) {
+		return nil, errors.New("not a ClientHello")
+	}
+
+	var h rawClientHello
+	if !body.ReadUint16(&h.version) || !body.Skip(32) ||
+		!body.ReadUint8LengthPrefixed(&sessionID) || !body.ReadUint16LengthPrefixed(&suites) ||
+		!body.ReadUint8LengthPrefixed(&compression) {
+		return nil, errors.New("malformed ClientHello")
+	}
+	var err error
+	if h.cipherSuites, err = readUint16s(suites); err != nil {
+		return nil, err
+	}
+	if body.Empty() {
+		return &h, nil
+	}
+
+	var exts cryptobyte.String
+	if !body.ReadUint16LengthPrefixed(&exts) {
+		return nil, errors.New("malformed ClientHello extensions")
+	}
+	for !exts.Empty() {
+		var typ uint16
+		var data cryptobyte.String
+		if !exts.ReadUint16(&typ) || !exts.ReadUint16LengthPrefixed(&data) {
+			return nil, errors.New("malformed ClientHello extensions")
+		}
+		h.extensions = append(h.extensions, typ)
+
+		var list cryptobyte.String
+		switch typ {
+		case extSupportedGroups:
+			if !data.ReadUint16LengthPrefixed(&list) {
+				return nil, fmt.Errorf("malformed extension %d", typ)
+			}
+			h.curves, err = readUint16s(list)
+		case extECPointFormats:
+			if !data.ReadUint8LengthPrefixed(&list) {
+				return nil, fmt.Errorf("malformed extension %d", typ)
+			}
+			h.pointFormats = append([]uint8(nil), list...)
+		case extSignatureAlgorithms:
+			if !data.ReadUint16LengthPrefixed(&list) {
+				return nil, fmt.Errorf("malformed extension %d", typ)
+			}
+			h.signatureSchemes, err = readUint16s(list)
+		case extSupportedVersions:
+			if !data.ReadUint8LengthPrefixed(&list) {
+				return nil, fmt.Errorf("malformed extension %d", typ)
+			}
+			h.versions, err = readUint16s(list)
+		case extALPN:
+			if !data.ReadUint16LengthPrefixed(&list) {
+				return nil, fmt.Errorf("malformed extension %d", typ)
+			}
+			for !list.Empty() {
+				var proto cryptobyte.String
+				if !list.ReadUint8LengthPrefixed(&proto) {
+					return nil, fmt.Errorf("malformed extension %d", typ)
+				}
+				h.alpn = append(h.alpn, string(proto))
+			}
+		}
+		if err != nil {
+			return nil, fmt.Errorf("extension %d: %w", typ, err)
+		}
+	}
+	return &h, nil
+}
+
+func readUint16s(s cryptobyte.String) ([]uint16, error) {
+	var vs []uint16
+	for !s.Empty() {
+		var v uint16
+		if !s.ReadUint16(&v) {
+			return nil, errors.New("odd length")
+		}
+		vs = append(vs, v)
+	}
+	return vs, nil
+}
+
+// isGREASE tells whether a value is one of the reserved ones (RFC 8701) that clients send to keep servers honest.
+// Fingerprints leave them out, since they change from one connection to the next.
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+func withoutGREASE(vs []uint16) []uint16 {
+	out := make([]uint16, 0, len(vs))
+	for _, v := range vs {
+		if !isGREASE(v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// ja3 returns the JA3 string (version, ciphers, extensions, curves and point formats, in the client's order) and its
+// MD5 hash.
+func (h *rawClientHello) ja3() (string, string) {
+	decimal := func(vs []uint16) string {
+		parts := make([]string, 0, len(vs))
+		for _, v := range withoutGREASE(vs) {
+			parts = append(parts, strconv.Itoa(int(v)))
+		}
+		return strings.Join(parts, "-")
+	}
+	formats := make([]string, 0, len(h.pointFormats))
+	for _, f := range h.pointFormats {
+		formats = append(formats, strconv.Itoa(int(f)))
+	}
+
+	s := strings.Join([]string{
+		strconv.Itoa(int(h.version)),
+		decimal(h.cipherSuites),
+		decimal(h.extensions),
+		decimal(h.curves),
+		strings.Join(formats, "-"),
+	}, ",")
+	sum := md5.Sum([]byte(s))
+	return s, hex.EncodeToString(sum[:])
+}
+
+// ja4 returns the JA4 fingerprint of a ClientHello received over TCP.
+func (h *rawClientHello) ja4() string {
+	suites := withoutGREASE(h.cipherSuites)
+	exts := withoutGREASE(h.extensions)
+
+	// supported_versions, if there is one, takes over from legacy_version
+	version := h.version
+	if versions := withoutGREASE(h.versions); len(versions) > 0 {
+		version = 0
+		for _, v := range versions {
+			if v > version {
+				version = v
+			}
+		}
+	}
+
+	sni := "i"
+	var sorted []uint16
+	for _, e := range exts {
+		switch e {
+		case extServerName:
+			sni = "d"
+		case extALPN:
+		default:
+			sorted = append(sorted, e)
+		}
+	}
+
+	a := fmt.Sprintf("t%s%s%02d%02d%s", ja4Version(version), sni, min99(len(suites)), min99(len(exts)), ja4ALPN(h.alpn))
+
+	sortedSuites := append([]uint16(nil), suites...)
+	sort.Slice(sortedSuites, func(i, j int) bool { return sortedSuites[i] < sortedSuites[j] })
+	b := ja4Hash(hexList(sortedSuites))
+
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	c := hexList(sorted)
+	if schemes := withoutGREASE(h.signatureSchemes); len(schemes) > 0 {
+		c += "_" + hexList(schemes)
+	}
+	if len(sorted) == 0 {
+		c = ""
+	}
+
+	return a + "_" + b + "_" + ja4Hash(c)
+}
+
+func ja4Version(v uint16) string {
+	switch v {
+	case 0x0304:
+		return "13"
+	case 0x0303:
+		return "12"
+	case 0x0302:
+		return "11"
+	case 0x0301:
+		return "10"
+	case 0x0300:
+		return "s3"
+	case 0x0200:
+		return "s2"
+	default:
+		return "00"
+	}
+}
+
+// ja4ALPN is the first and last characters of the first protocol, or of its hex if either isn't alphanumeric.
+func ja4ALPN(alpn []string) string {
+	if len(alpn) == 0 || alpn[0] == "" {
+		return "00"
+	}
+	p := alpn[0]
+	if !isAlphanumeric(p[0]) || !isAlphanumeric(p[len(p)-1]) {
+		p = hex.EncodeToString([]byte(p))
+	}
+	return p[:1] + p[len(p)-1:]
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func min99(n int) int {
+	if n > 99 {
+		return 99
+	}
+	return n
+}
+
+func hexList(vs []uint16) string {
+	parts := make([]string, 0, len(vs))
+	for _, v := range vs {
+		parts = append(parts, fmt.Sprintf("%04x", v))
+	}
+	return strings.Join(parts, ",")
+}
+
+// ja4Hash is the first 12 hex digits of the SHA-256 of s, or zeros if there's nothing to hash.
+func ja4Hash(s string) string {
+	if s == "" {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])[:12]
+}
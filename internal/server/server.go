@@ -3,12 +3,12 @@ package server
 import (
 	"context"
 	"crypto/tls"
-	"errors"
 	"fmt"
 	"log"
 	"net"
 	"sync"
-	"time"
+
+	"golang.org/x/crypto/acme"
 
 	"tls-tools/internal/config"
 	"tls-tools/internal/pki"
@@ -17,35 +17,163 @@ import (
 func NewServerFromConfig(cfg map[string]config.Listener, store pki.Store) (*Server, error) {
 	server := Server{}
 	for addr, l := range cfg {
-		tc, err := l.ToTLSConfig()
+		if len(l.Certs) == 0 && len(l.SniOverrides) == 0 && l.NoSNICert == "" && l.ACME == nil {
+			return nil, fmt.Errorf("no certs specified for %s", addr)
+		}
+
+		tc, sni, err := newTLSConfig(l, store)
 		if err != nil {
-			return nil, err
+			return nil, fmt.Errorf("%s: %w", addr, err)
 		}
 
-		if len(l.Certs) == 0 {
-			return nil, fmt.Errorf("no certs specified for %s", addr)
+		lc := ListenerConfig{
+			Addr:    addr,
+			TLSConf: tc,
+			sni:     sni,
+		}
+
+		lc.app, err = newApp(l)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", addr, err)
+		}
+		if lc.app.speaksHTTP() {
+			advertiseHTTP(tc)
 		}
 
-		for _, name := range l.Certs {
-			kac, ok := store[name]
-			if !ok {
-				return nil, fmt.Errorf("certificate not found: %s", name)
+		if l.ACME != nil {
+			lc.acme, err = newACMECertSource(*l.ACME, store)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", addr, err)
 			}
-			tc.Certificates = append(tc.Certificates, tls.Certificate{
-				Certificate: kac.GetCertChainDER(),
-				PrivateKey:  kac.GetPrivateKey(),
-			})
+			if lc.sni != nil {
+				lc.sni.next = lc.acme.GetCertificate
+			} else {
+				tc.GetCertificate = lc.acme.GetCertificate
+			}
+			tc.NextProtos = append(tc.NextProtos, acme.ALPNProto)
 		}
 
-		server.ListenerConfigs = append(server.ListenerConfigs, ListenerConfig{
-			Addr:    addr,
-			TLSConf: tc,
-		})
+		if len(l.VirtualHosts) > 0 {
+			vh, err := newVirtualHosts(l, store)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", addr, err)
+			}
+			tc.GetConfigForClient = vh.GetConfigForClient
+			if lc.app.speaksHTTP() {
+				for _, vtc := range vh.configs {
+					advertiseHTTP(vtc)
+				}
+			}
+		}
+
+		if l.RequireALPN {
+			if len(tc.NextProtos) == 0 {
+				return nil, fmt.Errorf("%s: requireAlpn needs alpn", addr)
+			}
+			tc.GetConfigForClient = requireALPN(tc)
+		}
+
+		tc.GetConfigForClient = logClientHellos(addr, tc.GetConfigForClient)
+
+		server.ListenerConfigs = append(server.ListenerConfigs, lc)
 	}
 
 	return &server, nil
 }
 
+// newTLSConfig sets up the TLS config for a listener or virtual host, along with its SNI selector if it has one.
+func newTLSConfig(l config.Listener, store pki.Store) (*tls.Config, *sniSelector, error) {
+	tc, err := l.ToTLSConfig()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var st staplers
+	if l.Stapling != nil {
+		st = make(staplers)
+	}
+
+	for _, name := range l.Certs {
+		crt, err := newCertificate(name, l, store, st)
+		if err != nil {
+			return nil, nil, err
+		}
+		tc.Certificates = append(tc.Certificates, *crt)
+	}
+
+	if l.ClientAuth != nil {
+		err = configureClientAuth(*l.ClientAuth, tc, store)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	// Staples are refreshed as certs are picked, so stapling listeners always pick them with a selector
+	if len(l.SniOverrides) == 0 && l.NoSNICert == "" && !l.RejectUnknownSNI && st == nil {
+		return tc, nil, nil
+	}
+
+	sni, err := newListenerSNISelector(l, tc.Certificates, store, st)
+	if err != nil {
+		return nil, nil, err
+	}
+	// With no certs of its own, crypto/tls asks the selector even when there's no SNI
+	tc.Certificates = nil
+	tc.GetCertificate = sni.GetCertificate
+	if st != nil {
+		tc.GetCertificate = st.refresh(sni.GetCertificate)
+	}
+	return tc, sni, nil
+}
+
+// newCertificate returns the named cert with its chain, and a stapled OCSP response if the listener has one, which is
+// kept fresh by st.
+func newCertificate(name string, l config.Listener, store pki.Store, st staplers) (*tls.Certificate, error) {
+	kac, ok := store[name]
+	if !ok {
+		return nil, fmt.Errorf("certificate not found: %s", name)
+	}
+	if kac.GetPrivateKey() == nil {
+		return nil, fmt.Errorf("no private key for certificate: %s", name)
+	}
+	crt := tls.Certificate{
+		Certificate: kac.GetCertChainDER(),
+		PrivateKey:  kac.GetPrivateKey(),
+		Leaf:        kac.GetCertificate(),
+	}
+	if l.Stapling != nil {
+		s, err := newStapler(name, *l.Stapling, store)
+		if err != nil {
+			return nil, err
+		}
+		crt.OCSPStaple = s.staple
+		st[crt.Leaf] = s
+	}
+	return &crt, nil
+}
+
+func newListenerSNISelector(l config.Listener, certs []tls.Certificate, store pki.Store, st staplers) (*sniSelector, error) {
+	overrides := make(map[string]*tls.Certificate, len(l.SniOverrides))
+	for pattern, name := range l.SniOverrides {
+		crt, err := newCertificate(name, l, store, st)
+		if err != nil {
+			return nil, err
+		}
+		overrides[pattern] = crt
+	}
+
+	var noSNI *tls.Certificate
+	if l.NoSNICert != "" {
+		var err error
+		noSNI, err = newCertificate(l.NoSNICert, l, store, st)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return newSNISelector(overrides, noSNI, certs, l.RejectUnknownSNI)
+}
+
 type Server struct {
 	ListenerConfigs []ListenerConfig
 }
@@ -62,33 +190,18 @@ func (s *Server) Start(ctx context.Context) {
 func listen(ctx context.Context, cfg ListenerConfig, wg *sync.WaitGroup) {
 	defer wg.Done()
 
-	l, err := tls.Listen("tcp", cfg.Addr, cfg.TLSConf)
+	nl, err := net.Listen("tcp", cfg.Addr)
 	if err != nil {
-		log.Println(fmt.Errorf("tls.Listen: %w", err))
+		log.Println(fmt.Errorf("net.Listen: %w", err))
 		return
 	}
+	l := tls.NewListener(helloListener{nl}, cfg.TLSConf)
 
-	go func() {
-		for {
-			c, err := l.Accept()
-			if err != nil {
-				if errors.Is(err, net.ErrClosed) {
-					break
-				}
-				log.Println(err)
-				continue
-			}
-			err = c.SetReadDeadline(time.Now().Add(500 * time.Millisecond))
-			if err != nil {
-				log.Println(fmt.Errorf("c.SetDeadline: %w", err))
-			}
-			_, _ = c.Read(nil)
-			err = c.Close()
-			if err != nil {
-				log.Println(fmt.Errorf("c.Close: %w", err))
-			}
-		}
-	}()
+	if cfg.acme != nil {
+		go cfg.acme.run(ctx)
+	}
+
+	go cfg.app.serve(l)
 
 	<-ctx.Done()
 	err = l.Close()
@@ -100,4 +213,7 @@ func listen(ctx context.Context, cfg ListenerConfig, wg *sync.WaitGroup) {
 type ListenerConfig struct {
 	Addr    string
 	TLSConf *tls.Config
+	acme    *acmeCertSource
+	sni     *sniSelector
+	app     app
 }
@@ -0,0 +1,40 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/pki"
+)
+
+func TestNewServerFromConfig_chainOrder(t *testing.T) {
+	store, err := pki.NewStoreFromConfig(map[string]config.Cert{
+		"root":         {KeyType: "P-256", Purpose: "root-ca"},
+		"intermediate": {KeyType: "P-256", Purpose: "intermediate-ca", Parent: "root"},
+		"leaf":         {KeyType: "P-256", Parent: "intermediate", DNSNames: []string{"leaf.test"}},
+	})
+	assert.Nil(t, err)
+
+	srv, err := NewServerFromConfig(map[string]config.Listener{"chain": {Certs: []string{"leaf"}}}, store)
+	assert.Nil(t, err)
+
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+	go func() {
+		_ = tls.Server(s, srv.ListenerConfigs[0].TLSConf).Handshake()
+	}()
+	tc := tls.Client(c, &tls.Config{ServerName: "leaf.test", InsecureSkipVerify: true})
+	assert.Nil(t, tc.Handshake())
+
+	// each cert is followed by its issuer, as TLS requires
+	var sent [][]byte
+	for _, crt := range tc.ConnectionState().PeerCertificates {
+		sent = append(sent, crt.Raw)
+	}
+	assert.Equal(t, [][]byte{store["leaf"].GetCertDER(), store["intermediate"].GetCertDER(), store["root"].GetCertDER()}, sent)
+}
@@ -0,0 +1,127 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// sniSelector picks a listener's cert by the server name in the ClientHello. Names without an override get one of the
+// listener's certs, like crypto/tls would pick, unless unknown names are rejected.
+type sniSelector struct {
+	names         serverNames
+	overrides     map[string]*tls.Certificate // by pattern
+	noSNI         *tls.Certificate
+	certs         []tls.Certificate
+	rejectUnknown bool
+	next          func(*tls.ClientHelloInfo) (*tls.Certificate, error)
+}
+
+func newSNISelector(overrides map[string]*tls.Certificate, noSNI *tls.Certificate, certs []tls.Certificate, rejectUnknown bool) (*sniSelector, error) {
+	patterns := make([]string, 0, len(overrides))
+	for p := range overrides {
+		patterns = append(patterns, p)
+	}
+	names, err := newServerNames(patterns)
+	if err != nil {
+		return nil, err
+	}
+
+	return &sniSelector{
+		names:         names,
+		overrides:     overrides,
+		noSNI:         noSNI,
+		certs:         certs,
+		rejectUnknown: rejectUnknown,
+	}, nil
+}
+
+// GetCertificate returns nil to abort the handshake with an unrecognized_name alert, which crypto/tls sends when there
+// are no certs to fall back on.
+func (s *sniSelector) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := normalizeServerName(hello.ServerName)
+	if name == "" {
+		if s.noSNI != nil {
+			return s.noSNI, nil
+		}
+		return s.defaultCertificate(hello), nil
+	}
+
+	if s.next != nil {
+		crt, err := s.next(hello)
+		if crt != nil || err != nil {
+			return crt, err
+		}
+	}
+
+	if pattern, ok := s.names.match(name); ok {
+		return s.overrides[pattern], nil
+	}
+
+	if s.rejectUnknown {
+		for i := range s.certs {
+			if leaf := s.certs[i].Leaf; leaf != nil && leaf.VerifyHostname(name) == nil {
+				return &s.certs[i], nil
+			}
+		}
+		log.Printf("Rejecting unknown server name %q", hello.ServerName)
+		return nil, nil
+	}
+
+	return s.defaultCertificate(hello), nil
+}
+
+func (s *sniSelector) defaultCertificate(hello *tls.ClientHelloInfo) *tls.Certificate {
+	if len(s.certs) == 0 {
+		return nil
+	}
+	for i := range s.certs {
+		if hello.SupportsCertificate(&s.certs[i]) == nil {
+			return &s.certs[i]
+		}
+	}
+	return &s.certs[0]
+}
+
+// serverNames matches server names against exact names, which take precedence, and wildcards like *.example.com, which
+// match a single label.
+type serverNames struct {
+	exact     map[string]string // patterns by name
+	wildcards map[string]string // patterns by the suffix after "*."
+}
+
+func newServerNames(patterns []string) (serverNames, error) {
+	n := serverNames{exact: make(map[string]string), wildcards: make(map[string]string)}
+	for _, pattern := range patterns {
+		p := normalizeServerName(pattern)
+		if suffix := strings.TrimPrefix(p, "*."); suffix != p {
+			if suffix == "" || strings.Contains(suffix, "*") {
+				return n, fmt.Errorf("invalid SNI pattern: %s", pattern)
+			}
+			n.wildcards[suffix] = pattern
+		} else if p == "" || strings.Contains(p, "*") {
+			return n, fmt.Errorf("invalid SNI pattern: %s", pattern)
+		} else {
+			n.exact[p] = pattern
+		}
+	}
+	return n, nil
+}
+
+// match returns the pattern that matches a normalized server name.
+func (n serverNames) match(name string) (string, bool) {
+	if pattern, ok := n.exact[name]; ok {
+		return pattern, true
+	}
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		if pattern, ok := n.wildcards[name[i+1:]]; ok {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+func normalizeServerName(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
@@ -0,0 +1,78 @@
+package server
+
+import (
+	"crypto/tls"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/pki"
+)
+
+func TestNewServerFromConfig_sniOverrides(t *testing.T) {
+	store, err := pki.NewStoreFromConfig(map[string]config.Cert{
+		"ca":       {KeyType: "P-256", Purpose: "root-ca"},
+		"default":  {KeyType: "P-256", Parent: "ca", DNSNames: []string{"default.test"}},
+		"exact":    {KeyType: "P-256", Parent: "ca", DNSNames: []string{"exact.example.com"}},
+		"wildcard": {KeyType: "P-256", Parent: "ca", DNSNames: []string{"*.example.com"}},
+		"nosni":    {KeyType: "P-256", Parent: "ca", DNSNames: []string{"nosni.test"}},
+	})
+	assert.Nil(t, err)
+
+	srv, err := NewServerFromConfig(map[string]config.Listener{
+		"sni": {
+			Certs:        []string{"default"},
+			SniOverrides: map[string]string{"Exact.Example.com": "exact", "*.example.com": "wildcard"},
+			NoSNICert:    "nosni",
+		},
+		"strict": {Certs: []string{"default"}, RejectUnknownSNI: true},
+	}, store)
+	assert.Nil(t, err)
+	listeners := map[string]*tls.Config{}
+	for _, lc := range srv.ListenerConfigs {
+		listeners[lc.Addr] = lc.TLSConf
+	}
+
+	for serverName, expected := range map[string]string{
+		"exact.example.com": "exact",
+		"www.example.com":   "wildcard",
+		"a.b.example.com":   "default",
+		"other.test":        "default",
+		"":                  "nosni",
+	} {
+		cs, err := handshake(listeners["sni"], serverName)
+		if assert.Nil(t, err, serverName) {
+			assert.Equal(t, store[expected].GetCertDER(), cs.PeerCertificates[0].Raw, serverName)
+		}
+	}
+
+	cs, err := handshake(listeners["strict"], "default.test")
+	if assert.Nil(t, err) {
+		assert.Equal(t, store["default"].GetCertDER(), cs.PeerCertificates[0].Raw)
+	}
+	_, err = handshake(listeners["strict"], "unknown.test")
+	assert.ErrorContains(t, err, "unrecognized name")
+
+	_, err = NewServerFromConfig(map[string]config.Listener{
+		"bad": {SniOverrides: map[string]string{"*.*.example.com": "exact"}},
+	}, store)
+	assert.NotNil(t, err)
+}
+
+// handshake connects to a server with the given config over a pipe.
+func handshake(serverConf *tls.Config, serverName string) (tls.ConnectionState, error) {
+	c, s := net.Pipe()
+	defer c.Close()
+	defer s.Close()
+
+	go func() {
+		_ = tls.Server(s, serverConf).Handshake()
+		_ = s.Close()
+	}()
+
+	tc := tls.Client(c, &tls.Config{ServerName: serverName, InsecureSkipVerify: true})
+	err := tc.Handshake()
+	return tc.ConnectionState(), err
+}
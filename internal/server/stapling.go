@@ -0,0 +1,118 @@
+package server
+
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/pki"
+)
+
+// staple returns the OCSP response to staple to the named cert, or nil if there shouldn't be one.
+func staple(name string, cfg config.Stapling, store pki.Store) ([]byte, error) {
+	for _, n := range cfg.Omit {
+		if n == name {
+			return nil, nil
+		}
+	}
+
+	if cfg.WrongCert != "" {
+		name = cfg.WrongCert
+	}
+	kac, ok := store[name]
+	if !ok {
+		return nil, fmt.Errorf("certificate not found: %s", name)
+	}
+
+	issuer := kac.GetParentName()
+	if issuer == "" {
+		return nil, fmt.Errorf("cannot staple an OCSP response for self-signed certificate: %s", name)
+	}
+
+	req := &ocsp.Request{HashAlgorithm: crypto.SHA1, SerialNumber: kac.GetCertificate().SerialNumber}
+	return store.NewOCSPResponse(issuer, req, nil, cfg.Response)
+}
+
+// staplerRetry is how long a stapler waits to try again after failing to generate a response, or when its response
+// has no useful validity window.
+const staplerRetry = time.Hour
+
+// stapler keeps the OCSP staple for a cert fresh, generating it again halfway to its nextUpdate.
+type stapler struct {
+	name  string
+	cfg   config.Stapling
+	store pki.Store
+
+	mu        sync.Mutex
+	staple    []byte
+	refreshAt time.Time // zero if there's nothing to refresh
+}
+
+func newStapler(name string, cfg config.Stapling, store pki.Store) (*stapler, error) {
+	s := stapler{name: name, cfg: cfg, store: store}
+	return &s, s.refresh(time.Now())
+}
+
+func (s *stapler) refresh(now time.Time) error {
+	der, err := staple(s.name, s.cfg, s.store)
+	if err != nil {
+		return err
+	}
+	s.staple = der
+	s.refreshAt = time.Time{}
+	if der == nil {
+		return nil
+	}
+
+	s.refreshAt = now.Add(staplerRetry)
+	resp, err := ocsp.ParseResponse(der, nil)
+	if err == nil && !resp.NextUpdate.IsZero() {
+		if halfway := resp.ThisUpdate.Add(resp.NextUpdate.Sub(resp.ThisUpdate) / 2); halfway.After(now) {
+			s.refreshAt = halfway
+		}
+	}
+	return nil
+}
+
+// get returns the current staple, refreshing it first if it's due.
+func (s *stapler) get() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if !s.refreshAt.IsZero() && now.After(s.refreshAt) {
+		err := s.refresh(now)
+		if err != nil {
+			log.Printf("Failed to refresh the OCSP staple for %s: %v", s.name, err)
+			s.refreshAt = now.Add(staplerRetry)
+		}
+	}
+	return s.staple
+}
+
+// staplers are the staplers of a TLS config's certs, by leaf.
+type staplers map[*x509.Certificate]*stapler
+
+// refresh wraps a GetCertificate to return the cert it picks with a fresh staple.
+func (st staplers) refresh(next func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		crt, err := next(hello)
+		if crt == nil || err != nil {
+			return crt, err
+		}
+		s, ok := st[crt.Leaf]
+		if !ok {
+			return crt, nil
+		}
+		fresh := *crt
+		fresh.OCSPStaple = s.get()
+		return &fresh, nil
+	}
+}
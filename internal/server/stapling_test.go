@@ -0,0 +1,100 @@
+package server
+
+import (
+	"crypto/tls"
+	"encoding/asn1"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ocsp"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/pki"
+)
+
+func TestNewServerFromConfig_stapling(t *testing.T) {
+	store, err := pki.NewStoreFromConfig(map[string]config.Cert{
+		"ca":     {KeyType: "P-256", Purpose: "root-ca"},
+		"leaf":   {KeyType: "P-256", Parent: "ca", MustStaple: true, DNSNames: []string{"leaf.test"}},
+		"other":  {KeyType: "P-256", Parent: "ca"},
+		"staple": {KeyType: "P-256", Parent: "ca", MustStaple: true, DNSNames: []string{"staple.test"}},
+	})
+	assert.Nil(t, err)
+	ca := store["ca"].GetCertificate()
+	leaf := store["leaf"].GetCertificate()
+
+	hasMustStaple := false
+	for _, ext := range leaf.Extensions {
+		hasMustStaple = hasMustStaple || ext.Id.Equal(asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 1, 24})
+	}
+	assert.True(t, hasMustStaple)
+
+	srv, err := NewServerFromConfig(map[string]config.Listener{
+		"good":  {Certs: []string{"leaf"}, Stapling: &config.Stapling{}},
+		"bad":   {Certs: []string{"leaf"}, Stapling: &config.Stapling{Response: config.OCSPResponse{Status: "revoked", Expired: true}}},
+		"wrong": {Certs: []string{"leaf"}, Stapling: &config.Stapling{WrongCert: "other"}},
+		"none":  {Certs: []string{"leaf", "staple"}, Stapling: &config.Stapling{Omit: []string{"leaf"}}},
+	}, store)
+	assert.Nil(t, err)
+
+	listeners := map[string]*tls.Config{}
+	for _, lc := range srv.ListenerConfigs {
+		listeners[lc.Addr] = lc.TLSConf
+	}
+	staples := map[string][][]byte{}
+	for addr, tc := range listeners {
+		for _, name := range []string{"leaf.test", "staple.test"} {
+			cs, err := handshake(tc, name)
+			assert.Nil(t, err)
+			staples[addr] = append(staples[addr], cs.OCSPResponse)
+		}
+	}
+
+	resp, err := ocsp.ParseResponseForCert(staples["good"][0], leaf, ca)
+	assert.Nil(t, err)
+	assert.Equal(t, ocsp.Good, resp.Status)
+
+	resp, err = ocsp.ParseResponseForCert(staples["bad"][0], leaf, ca)
+	assert.Nil(t, err)
+	assert.Equal(t, ocsp.Revoked, resp.Status)
+	assert.True(t, resp.NextUpdate.Before(time.Now()))
+
+	resp, err = ocsp.ParseResponse(staples["wrong"][0], ca)
+	assert.Nil(t, err)
+	assert.Equal(t, store["other"].GetCertificate().SerialNumber, resp.SerialNumber)
+
+	assert.Empty(t, staples["none"][0])
+	assert.NotNil(t, staples["none"][1])
+}
+
+func TestNewServerFromConfig_staplingRefresh(t *testing.T) {
+	store, err := pki.NewStoreFromConfig(map[string]config.Cert{
+		"ca":   {KeyType: "P-256", Purpose: "root-ca"},
+		"leaf": {KeyType: "P-256", Parent: "ca", DNSNames: []string{"leaf.test"}},
+	})
+	assert.Nil(t, err)
+
+	srv, err := NewServerFromConfig(map[string]config.Listener{
+		"short": {Certs: []string{"leaf"}, Stapling: &config.Stapling{Response: config.OCSPResponse{NextUpdate: "2s"}}},
+	}, store)
+	assert.Nil(t, err)
+	tc := srv.ListenerConfigs[0].TLSConf
+
+	thisUpdate := func() time.Time {
+		cs, err := handshake(tc, "leaf.test")
+		assert.Nil(t, err)
+		resp, err := ocsp.ParseResponseForCert(cs.OCSPResponse, store["leaf"].GetCertificate(), store["ca"].GetCertificate())
+		if !assert.Nil(t, err) {
+			return time.Time{}
+		}
+		assert.True(t, resp.NextUpdate.After(time.Now()), "stale staple")
+		return resp.ThisUpdate
+	}
+
+	first := thisUpdate()
+	assert.Equal(t, first, thisUpdate())
+	// halfway to nextUpdate, the response is generated again
+	time.Sleep(1500 * time.Millisecond)
+	assert.True(t, thisUpdate().After(first))
+}
@@ -0,0 +1,73 @@
+package server
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	"golang.org/x/crypto/acme"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/pki"
+)
+
+// virtualHosts swaps in a whole TLS config by server name, the way a load balancer in front of several sites does.
+// Names without a virtual host, and clients without SNI, get the listener's own config.
+type virtualHosts struct {
+	names   serverNames
+	configs map[string]*tls.Config // by pattern
+}
+
+func newVirtualHosts(l config.Listener, store pki.Store) (*virtualHosts, error) {
+	vh := virtualHosts{configs: make(map[string]*tls.Config, len(l.VirtualHosts))}
+
+	patterns := make([]string, 0, len(l.VirtualHosts))
+	for pattern, h := range l.VirtualHosts {
+		if h.ACME != nil {
+			return nil, fmt.Errorf("%s: ACME is only supported on the listener", pattern)
+		}
+		if h.App != "" || h.StaticDir != "" {
+			return nil, fmt.Errorf("%s: apps are only set on the listener", pattern)
+		}
+		if h.RequireALPN {
+			return nil, fmt.Errorf("%s: requireAlpn is only set on the listener", pattern)
+		}
+		if len(h.VirtualHosts) > 0 {
+			return nil, fmt.Errorf("%s: virtual hosts can't have virtual hosts", pattern)
+		}
+		if len(h.Certs) == 0 && len(h.SniOverrides) == 0 && h.NoSNICert == "" {
+			h.Certs = l.Certs
+			if len(h.Certs) == 0 {
+				return nil, fmt.Errorf("%s: no certs specified", pattern)
+			}
+		}
+
+		tc, _, err := newTLSConfig(h, store)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", pattern, err)
+		}
+		vh.configs[pattern] = tc
+		patterns = append(patterns, pattern)
+	}
+
+	var err error
+	vh.names, err = newServerNames(patterns)
+	if err != nil {
+		return nil, err
+	}
+	return &vh, nil
+}
+
+func (v *virtualHosts) GetConfigForClient(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+	// tls-alpn-01 challenges are answered by the listener
+	for _, p := range hello.SupportedProtos {
+		if p == acme.ALPNProto {
+			return nil, nil
+		}
+	}
+
+	pattern, ok := v.names.match(normalizeServerName(hello.ServerName))
+	if !ok {
+		return nil, nil
+	}
+	return v.configs[pattern], nil
+}
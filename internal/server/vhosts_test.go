@@ -0,0 +1,55 @@
+package server
+
+import (
+	"crypto/tls"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"tls-tools/internal/config"
+	"tls-tools/internal/pki"
+)
+
+func TestNewServerFromConfig_virtualHosts(t *testing.T) {
+	store, err := pki.NewStoreFromConfig(map[string]config.Cert{
+		"ca":     {KeyType: "P-256", Purpose: "root-ca"},
+		"modern": {KeyType: "P-256", Parent: "ca", DNSNames: []string{"modern.test"}},
+		"legacy": {KeyType: "P-256", Parent: "ca", DNSNames: []string{"*.legacy.test"}},
+	})
+	assert.Nil(t, err)
+
+	srv, err := NewServerFromConfig(map[string]config.Listener{
+		"vhosts": {
+			Certs: []string{"modern"},
+			VirtualHosts: map[string]config.Listener{
+				"*.legacy.test": {Certs: []string{"legacy"}, MaxTLSVersion: "1.2"},
+				"old.test":      {MaxTLSVersion: "1.1"},
+			},
+		},
+	}, store)
+	assert.Nil(t, err)
+	tc := srv.ListenerConfigs[0].TLSConf
+
+	cs, err := handshake(tc, "modern.test")
+	if assert.Nil(t, err) {
+		assert.Equal(t, uint16(tls.VersionTLS13), cs.Version)
+		assert.Equal(t, store["modern"].GetCertDER(), cs.PeerCertificates[0].Raw)
+	}
+
+	cs, err = handshake(tc, "www.legacy.test")
+	if assert.Nil(t, err) {
+		assert.Equal(t, uint16(tls.VersionTLS12), cs.Version)
+		assert.Equal(t, store["legacy"].GetCertDER(), cs.PeerCertificates[0].Raw)
+	}
+
+	// crypto/tls clients refuse anything older than TLS 1.2 by default
+	_, err = handshake(tc, "old.test")
+	assert.ErrorContains(t, err, "protocol version")
+
+	_, err = NewServerFromConfig(map[string]config.Listener{
+		"nested": {Certs: []string{"modern"}, VirtualHosts: map[string]config.Listener{
+			"a.test": {VirtualHosts: map[string]config.Listener{"b.test": {}}},
+		}},
+	}, store)
+	assert.NotNil(t, err)
+}